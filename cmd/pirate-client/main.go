@@ -3,8 +3,10 @@ package main
 import (
 	"bytes"
 	"compress/gzip"
+	"encoding/binary"
 	"flag"
 	"fmt"
+	"github.com/klauspost/compress/zstd"
 	"log"
 	"math/rand"
 	"net"
@@ -20,6 +22,8 @@ func main() {
 	max := flag.Float64("max", 0, "Maximum for random value")
 	frequency := flag.Duration("freq", 500*time.Millisecond, "Frequency to generate metrics")
 	compression := flag.Bool("gzip", false, "Use gzip compression")
+	zstdDict := flag.String("zstd-dict", "", "Use zstd compression with the given dictionary")
+	capture := flag.String("capture", "", "Append the plain messages to a capture file for training dictionaries")
 	flag.Parse()
 
 	type metric struct {
@@ -40,6 +44,26 @@ func main() {
 	}
 	defer conn.Close()
 
+	var zstdWriter *zstd.Encoder
+	if *zstdDict != "" {
+		dict, err := os.ReadFile(*zstdDict)
+		if err != nil {
+			fail("Failed to read zstd dictionary: %s", err)
+		}
+
+		if zstdWriter, err = zstd.NewWriter(nil, zstd.WithEncoderDict(dict)); err != nil {
+			fail("Failed to load zstd dictionary: %s", err)
+		}
+	}
+
+	var captureFile *os.File
+	if *capture != "" {
+		if captureFile, err = os.OpenFile(*capture, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644); err != nil {
+			fail("Failed to open capture file: %s", err)
+		}
+		defer captureFile.Close()
+	}
+
 	for {
 		startTime := time.Now()
 
@@ -58,8 +82,19 @@ func main() {
 			fmt.Fprintf(buf, "%s %f %d\n", m.Name, m.Value, m.Timestamp)
 		}
 
-		// compress buffer, if gzip enabled
-		if *compression {
+		// append the message with length framing to the capture file
+		if captureFile != nil {
+			prefix := make([]byte, 4)
+			binary.BigEndian.PutUint32(prefix, uint32(buf.Len()))
+			if _, err := captureFile.Write(append(prefix, buf.Bytes()...)); err != nil {
+				fail("Failed to write capture file: %s", err)
+			}
+		}
+
+		// compress buffer, if gzip or zstd enabled
+		if zstdWriter != nil {
+			buf = bytes.NewBuffer(zstdWriter.EncodeAll(buf.Bytes(), nil))
+		} else if *compression {
 			gzBuf := bytes.NewBuffer(make([]byte, 0, 64*1024))
 			gzWriter := gzip.NewWriter(gzBuf)
 			buf.WriteTo(gzWriter)
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/klauspost/compress/dict"
+	"github.com/klauspost/compress/zstd"
+	"io"
+	"log"
+	"os"
+)
+
+// main trains a zstd dictionary from a capture file, which contains plain text messages with the length framing of the
+// TCP listener: each message is prefixed by its size as 4 byte unsigned big endian integer
+func main() {
+	capture := flag.String("capture", "", "Capture file with length prefixed sample messages")
+	out := flag.String("out", "pirate.dict", "Dictionary file to write")
+	id := flag.Uint("id", 0, "Dictionary ID, random if 0")
+	size := flag.Int("size", 16*1024, "Maximum dictionary size in bytes")
+	flag.Parse()
+
+	if *capture == "" {
+		fail("Missing capture file\n")
+	}
+
+	samples, err := readCapture(*capture)
+	if err != nil {
+		fail("Failed to read capture file: %s\n", err)
+	}
+
+	if len(samples) == 0 {
+		fail("Capture file %s contains no messages\n", *capture)
+	}
+
+	log.Printf("Training dictionary from %d messages\n", len(samples))
+
+	b, err := dict.BuildZstdDict(samples, dict.Options{
+		MaxDictSize: *size,
+		HashBytes:   6,
+		ZstdDictID:  uint32(*id),
+		ZstdLevel:   zstd.SpeedBestCompression,
+	})
+	if err != nil {
+		fail("Failed to train dictionary: %s\n", err)
+	}
+
+	if err := os.WriteFile(*out, b, 0644); err != nil {
+		fail("Failed to write dictionary: %s\n", err)
+	}
+
+	inspected, err := zstd.InspectDictionary(b)
+	if err != nil {
+		fail("Failed to inspect dictionary: %s\n", err)
+	}
+	log.Printf("Wrote dictionary %d with %d bytes to %s\n", inspected.ID(), len(b), *out)
+
+	report(samples, b)
+}
+
+func readCapture(filename string) ([][]byte, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	reader := bufio.NewReader(f)
+
+	var samples [][]byte
+	prefix := make([]byte, 4)
+	for {
+		if _, err := io.ReadFull(reader, prefix); err != nil {
+			if errors.Is(err, io.EOF) {
+				return samples, nil
+			}
+
+			return nil, err
+		}
+
+		sample := make([]byte, binary.BigEndian.Uint32(prefix))
+		if _, err := io.ReadFull(reader, sample); err != nil {
+			return nil, err
+		}
+
+		// skip keepalives
+		if len(sample) > 0 {
+			samples = append(samples, sample)
+		}
+	}
+}
+
+// report compares the average compressed size of the samples with and without the dictionary
+func report(samples [][]byte, b []byte) {
+	plain, err := zstd.NewWriter(nil)
+	if err != nil {
+		fail("Failed to create zstd encoder: %s\n", err)
+	}
+
+	withDict, err := zstd.NewWriter(nil, zstd.WithEncoderDict(b))
+	if err != nil {
+		fail("Failed to load dictionary: %s\n", err)
+	}
+
+	var size, plainSize, dictSize int
+	for _, sample := range samples {
+		size += len(sample)
+		plainSize += len(plain.EncodeAll(sample, nil))
+		dictSize += len(withDict.EncodeAll(sample, nil))
+	}
+
+	n := len(samples)
+	log.Printf("Average message size: %d bytes, zstd: %d bytes, zstd with dictionary: %d bytes\n", size/n, plainSize/n, dictSize/n)
+}
+
+func fail(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, format, args...)
+	os.Exit(1)
+}
@@ -6,7 +6,11 @@ import (
 	"github.com/innogames/pirate/pirate"
 	"github.com/op/go-logging"
 	"os"
+	"os/signal"
 	"runtime"
+	"sync"
+	"syscall"
+	"time"
 )
 
 func main() {
@@ -18,47 +22,240 @@ func main() {
 		fail("Failed to load configuration: %s\n", err)
 	}
 
-	logger := createLogger(cfg)
+	logger, logLevel := createLogger(cfg)
 	cfg.Log(logger)
 
-	chUdp := make(chan []byte, 100)
-	chUdpDecomp := make(chan []byte, 100)
+	chUdp := make(chan *pirate.Packet, 100)
+	chUdpDecomp := make(chan *pirate.Packet, 100)
 	chMsg := make(chan *pirate.Message, 100)
 	chValidMsg := make(chan *pirate.Message, 100)
 	chMetric := make(chan *pirate.Metric, 1000)
 
 	stats := pirate.NewMonitoringStats()
+	sharedCfg := pirate.NewSharedConfig(cfg)
 
-	server, err := pirate.NewUdpServer(cfg.UdpAddress, cfg.PerIpRateLimit, logger, stats, chUdp)
+	reloader := pirate.NewConfigReloader(*configFile, sharedCfg, logger, stats)
+	reloader.OnReload(func(cfg *pirate.Config) {
+		logLevel.SetLevel(cfg.LogLevel, "pirate")
+	})
+
+	// the rate limit is shared, so that clients can not bypass it by switching the protocol
+	limiter := pirate.NewIpLimiter(cfg.PerIpRateLimit.Amount, cfg.PerIpRateLimit.Interval)
+
+	server, err := pirate.NewUdpServer(&cfg.UdpConfig, cfg.UdpListeners, limiter, logger, stats, chUdp)
 	if err != nil {
 		fail("Failed to initialize server: %s\n", err)
 	}
 
-	writer, err := pirate.NewWriter(cfg.GraphiteTarget, logger, stats)
+	// HTTP bodies are decompressed by the server itself, so they skip the compression workers
+	var httpServer *pirate.HttpServer
+	if cfg.Http.Enabled {
+		if httpServer, err = pirate.NewHttpServer(cfg.Http, cfg.Decompression, limiter, logger, stats, chUdpDecomp); err != nil {
+			fail("Failed to initialize HTTP server: %s\n", err)
+		}
+	}
+
+	// length prefixed messages are decompressed like UDP packets, blank line separated ones can only be plain text
+	streamChannel := func(framing string) chan *pirate.Packet {
+		if framing == pirate.FramingBlankLine {
+			return chUdpDecomp
+		}
+		return chUdp
+	}
+
+	var tcpServer *pirate.StreamServer
+	if cfg.Tcp.Enabled {
+		tcpServer = pirate.NewTcpServer(cfg.Tcp, logger, stats, streamChannel(cfg.Tcp.Framing))
+	}
+
+	var unixgramServer *pirate.UnixgramServer
+	if cfg.Unix.DatagramPath != "" {
+		unixgramServer = pirate.NewUnixgramServer(cfg.Unix, limiter, logger, stats, chUdp)
+	}
+
+	var unixStreamServer *pirate.StreamServer
+	if cfg.Unix.StreamPath != "" {
+		unixStreamServer = pirate.NewUnixStreamServer(cfg.Unix, limiter, logger, stats, streamChannel(cfg.Unix.Framing))
+	}
+
+	router := pirate.NewRouter(createOutputs(cfg.Outputs, cfg, logger, stats), createOutputs(cfg.ProjectOutputs, cfg, logger, stats), logger, stats, chMetric)
+
+	compressionWorker, err := pirate.NewCompressionWorker(cfg.Compression, cfg.Decompression, cfg.ZstdDictionaries, limiter, logger, stats, chUdp, chUdpDecomp)
 	if err != nil {
-		fail("Failed to initialize writer: %s", err)
+		fail("Failed to initialize decompression: %s\n", err)
 	}
 
-	decompressor := pirate.NewPlainDecompressor()
-	if cfg.Gzip {
-		decompressor = pirate.NewGzipDecompressor()
+	validator := pirate.NewValidatorWorker(sharedCfg, logger, stats, chMsg, chValidMsg)
+
+	var exporter *pirate.PrometheusExporter
+	var chExporterMsg chan *pirate.Message
+	if cfg.Prometheus.Enabled {
+		chExporterMsg = make(chan *pirate.Message, 100)
+		validator.MirrorTo(chExporterMsg)
+		exporter = pirate.NewPrometheusExporter(cfg.Prometheus, logger, chExporterMsg)
 	}
 
 	numCpus := runtime.NumCPU()
+	monitoring := pirate.NewMonitoringWorker(sharedCfg, logger, chMetric, stats)
+	chDone := make(chan struct{})
+
+	// every stage closes the channel of its successor as soon as its input got drained
+	listeners := &sync.WaitGroup{}
+	listeners.Add(1)
+	go func() {
+		if err := server.Run(); err != nil {
+			fail("UDP Server error: %s", err)
+		}
+		listeners.Done()
+	}()
+	if httpServer != nil {
+		listeners.Add(1)
+		go func() {
+			if err := httpServer.Run(); err != nil {
+				fail("HTTP Server error: %s", err)
+			}
+			listeners.Done()
+		}()
+	}
+	if tcpServer != nil {
+		listeners.Add(1)
+		go func() {
+			if err := tcpServer.Run(); err != nil {
+				fail("TCP Server error: %s", err)
+			}
+			listeners.Done()
+		}()
+	}
+	if unixgramServer != nil {
+		listeners.Add(1)
+		go func() {
+			if err := unixgramServer.Run(); err != nil {
+				fail("Unix Datagram Server error: %s", err)
+			}
+			listeners.Done()
+		}()
+	}
+	if unixStreamServer != nil {
+		listeners.Add(1)
+		go func() {
+			if err := unixStreamServer.Run(); err != nil {
+				fail("Unix Stream Server error: %s", err)
+			}
+			listeners.Done()
+		}()
+	}
+	go func() {
+		listeners.Wait()
+		close(chUdp)
+	}()
+	go func() {
+		compressionWorker.Run(numCpus)
+		close(chUdpDecomp)
+	}()
+	go func() {
+		pirate.NewParserWorker(sharedCfg, logger, stats, chUdpDecomp, chMsg).Run(numCpus)
+		close(chMsg)
+	}()
+	go func() {
+		validator.Run(numCpus)
+		close(chValidMsg)
+		if chExporterMsg != nil {
+			close(chExporterMsg)
+		}
+	}()
+	go func() {
+		pirate.NewMetricWorker(logger, chValidMsg, chMetric).Run(numCpus)
+		monitoring.Flush()
+		close(chMetric)
+	}()
+	go func() {
+		router.Run()
+		// the counters of draining the outputs are logged once all of them finished
+		monitoring.Stop()
+		close(chDone)
+	}()
+	go monitoring.Run()
 
-	go pirate.NewCompressionWorker(decompressor, logger, chUdp, chUdpDecomp).Run(numCpus)
-	go pirate.NewParserWorker(logger, chUdpDecomp, chMsg).Run(numCpus)
-	go pirate.NewValidatorWorker(cfg, logger, stats, chMsg, chValidMsg).Run(numCpus)
-	go pirate.NewMetricWorker(cfg, logger, chValidMsg, chMetric).Run(numCpus)
-	go pirate.NewWriterWorker(writer, logger, chMetric).Run(1)
-	go pirate.NewMonitoringWorker(cfg, logger, chMetric, stats).Run()
+	if exporter != nil {
+		go exporter.Run()
+		go func() {
+			if err := exporter.Serve(); err != nil {
+				fail("Prometheus exporter error: %s", err)
+			}
+		}()
+	}
+
+	var admin *pirate.AdminServer
+	if cfg.AdminAddress != "" {
+		admin = pirate.NewAdminServer(cfg.AdminAddress, logger)
+		admin.AddAction("/reload", func() (string, error) {
+			if err := reloader.Reload(); err != nil {
+				return "", err
+			}
+
+			return "Configuration reloaded", nil
+		})
+		admin.AddAction("/dead-letter/replay", router.ReplayDeadLetters)
+		go func() {
+			if err := admin.Run(); err != nil {
+				fail("Admin server error: %s", err)
+			}
+		}()
+	}
+
+	chSig := make(chan os.Signal, 1)
+	signal.Notify(chSig, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
 
-	if err := server.Run(); err != nil {
-		fail("UDP Server error: %s", err)
+	sig := <-chSig
+	for sig == syscall.SIGHUP {
+		reloader.Reload()
+		sig = <-chSig
+	}
+
+	logger.Noticef("[Shutdown] Received %s, draining pipeline within %s", sig, cfg.ShutdownTimeout)
+	server.Stop()
+	if tcpServer != nil {
+		tcpServer.Stop()
+	}
+	if unixgramServer != nil {
+		unixgramServer.Stop()
+	}
+	if unixStreamServer != nil {
+		unixStreamServer.Stop()
+	}
+	if httpServer != nil {
+		httpServer.Stop()
 	}
+	if admin != nil {
+		admin.Stop()
+	}
+	if exporter != nil {
+		exporter.Stop()
+	}
+
+	select {
+	case <-chDone:
+		logger.Notice("[Shutdown] Pipeline drained, bye")
+	case <-time.After(cfg.ShutdownTimeout):
+		fail("Shutdown deadline of %s exceeded, exiting with pending metrics\n", cfg.ShutdownTimeout)
+	}
+}
+
+func createOutputs(outputCfgs []*pirate.OutputConfig, cfg *pirate.Config, logger *logging.Logger, stats *pirate.MonitoringStats) []*pirate.Output {
+	outputs := make([]*pirate.Output, 0, len(outputCfgs))
+
+	for _, outputCfg := range outputCfgs {
+		output, err := pirate.NewOutput(outputCfg, cfg.WriterRetry, cfg.Spill, logger, stats)
+		if err != nil {
+			fail("Failed to initialize output: %s", err)
+		}
+		outputs = append(outputs, output)
+	}
+
+	return outputs
 }
 
-func createLogger(cfg *pirate.Config) *logging.Logger {
+func createLogger(cfg *pirate.Config) (*logging.Logger, logging.LeveledBackend) {
 	format := logging.MustStringFormatter(`%{time:2006-01-02 15:04:05.000} %{level:.4s} %{message}`)
 	logger := logging.MustGetLogger("pirate")
 	backend := logging.NewBackendFormatter(logging.NewLogBackend(os.Stdout, "", 0), format)
@@ -66,7 +263,7 @@ func createLogger(cfg *pirate.Config) *logging.Logger {
 	leveledBackend.SetLevel(cfg.LogLevel, "pirate")
 	logger.SetBackend(leveledBackend)
 
-	return logger
+	return logger, leveledBackend
 }
 
 func fail(format string, args ...interface{}) {
@@ -1,10 +1,13 @@
 module github.com/innogames/pirate
 
-go 1.24
+go 1.24.0
 
 require (
+	github.com/klauspost/compress v1.19.2
 	github.com/op/go-logging v0.0.0-20160315200505-970db520ece7
 	github.com/stretchr/testify v1.10.0
+	golang.org/x/net v0.45.0
+	golang.org/x/sys v0.36.0
 	gopkg.in/yaml.v2 v2.4.0
 )
 
@@ -0,0 +1,67 @@
+package pirate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/op/go-logging"
+	"net/http"
+	"time"
+)
+
+type AdminServer struct {
+	server *http.Server
+	mux    *http.ServeMux
+	logger *logging.Logger
+}
+
+type AdminAction func() (string, error)
+
+func NewAdminServer(address string, logger *logging.Logger) *AdminServer {
+	mux := http.NewServeMux()
+
+	server := &http.Server{
+		Addr:              address,
+		Handler:           mux,
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+
+	return &AdminServer{server, mux, logger}
+}
+
+// AddAction registers an action, which is triggered by a POST request on the given path.
+func (s *AdminServer) AddAction(path string, action AdminAction) {
+	s.mux.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		s.logger.Infof("[Admin] Triggered %s by %s", path, r.RemoteAddr)
+
+		result, err := action()
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed: %s", err), http.StatusUnprocessableEntity)
+			return
+		}
+
+		fmt.Fprintln(w, result)
+	})
+}
+
+func (s *AdminServer) Run() error {
+	s.logger.Infof("[Admin] Listening on %s", s.server.Addr)
+
+	if err := s.server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return fmt.Errorf("Unable to start admin server on %s: %s", s.server.Addr, err)
+	}
+
+	return nil
+}
+
+func (s *AdminServer) Stop() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	s.server.Shutdown(ctx)
+}
@@ -0,0 +1,71 @@
+package pirate
+
+import (
+	"bytes"
+	"sync"
+)
+
+const (
+	minPacketBufferSize = 512
+
+	// maxScratchBufferSize keeps exceptionally large scratch buffers from being pinned by the pool
+	maxScratchBufferSize = 4 * 1024 * 1024
+)
+
+// packetBufferPools holds buffers for received packets in power of two size classes from 512 bytes up to the maximum
+// UDP packet size
+var packetBufferPools [8]sync.Pool
+
+var scratchBufferPool = sync.Pool{
+	New: func() any {
+		return &bytes.Buffer{}
+	},
+}
+
+func packetBufferClass(size int) int {
+	class := 0
+	for n := minPacketBufferSize; n < size; n <<= 1 {
+		class++
+	}
+
+	return class
+}
+
+// getPacketBuffer returns a buffer of length n, which is taken from the pool if possible
+func getPacketBuffer(n int) []byte {
+	class := packetBufferClass(n)
+	if class >= len(packetBufferPools) {
+		return make([]byte, n)
+	}
+
+	if buf, ok := packetBufferPools[class].Get().(*[]byte); ok {
+		return (*buf)[:n]
+	}
+
+	return make([]byte, n, minPacketBufferSize<<class)
+}
+
+// releasePacketBuffer returns a buffer to the pool. The buffer must not be referenced anymore, buffers not matching
+// a size class are left to the garbage collector.
+func releasePacketBuffer(buf []byte) {
+	class := packetBufferClass(cap(buf))
+	if class >= len(packetBufferPools) || minPacketBufferSize<<class != cap(buf) {
+		return
+	}
+
+	buf = buf[:0]
+	packetBufferPools[class].Put(&buf)
+}
+
+func getScratchBuffer() *bytes.Buffer {
+	buf := scratchBufferPool.Get().(*bytes.Buffer)
+	buf.Reset()
+
+	return buf
+}
+
+func releaseScratchBuffer(buf *bytes.Buffer) {
+	if buf.Cap() <= maxScratchBufferSize {
+		scratchBufferPool.Put(buf)
+	}
+}
@@ -2,42 +2,329 @@ package pirate
 
 import (
 	"bytes"
+	"compress/flate"
 	"compress/gzip"
+	"compress/zlib"
+	"errors"
 	"fmt"
+	"github.com/klauspost/compress/zstd"
 	"github.com/op/go-logging"
 	"io"
+	"os"
 	"sync"
+	"unicode/utf8"
 )
 
-type DecompressFunc func(b []byte) ([]byte, error)
+const (
+	CompressionAuto    = "auto"
+	CompressionPlain   = "plain"
+	CompressionGzip    = "gzip"
+	CompressionZstd    = "zstd"
+	CompressionZlib    = "zlib"
+	CompressionDeflate = "deflate"
+)
+
+const (
+	// ZstdMaxWindow bounds the memory a zstd frame may request, larger windows are rejected
+	ZstdMaxWindow = 8 * 1024 * 1024
+)
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+
+	ErrDecompressionLimit = errors.New("Decompressed size exceeds limit")
+)
+
+// DecompressFunc decompresses b. With a positive limit, it fails with ErrDecompressionLimit as soon as the output
+// exceeds the limit, so that decompression bombs never get fully expanded in memory.
+type DecompressFunc func(b []byte, limit int) ([]byte, error)
 
 type compressionWorker struct {
-	decompress DecompressFunc
-	logger     *logging.Logger
-	chIn       <-chan []byte
-	chOut      chan<- []byte
+	compression   string
+	decompressors map[string]DecompressFunc
+	limits        *DecompressionConfig
+	limiter       *IpLimiter
+	logger        *logging.Logger
+	stats         *MonitoringStats
+	chIn          <-chan *Packet
+	chOut         chan<- *Packet
 }
 
 func NewPlainDecompressor() DecompressFunc {
-	return func(b []byte) ([]byte, error) {
+	return func(b []byte, limit int) ([]byte, error) {
 		return b, nil
 	}
 }
 
+// gzipDecoder keeps a reader together with the source it reads from, so that both can be reset for the next packet
+type gzipDecoder struct {
+	src    bytes.Reader
+	reader *gzip.Reader
+}
+
 func NewGzipDecompressor() DecompressFunc {
-	return func(b []byte) ([]byte, error) {
-		reader, err := gzip.NewReader(bytes.NewBuffer(b))
+	pool := &sync.Pool{
+		New: func() any {
+			return &gzipDecoder{}
+		},
+	}
+
+	return func(b []byte, limit int) ([]byte, error) {
+		decoder := pool.Get().(*gzipDecoder)
+		defer pool.Put(decoder)
+
+		decoder.src.Reset(b)
+
+		var err error
+		if decoder.reader == nil {
+			decoder.reader, err = gzip.NewReader(&decoder.src)
+		} else {
+			err = decoder.reader.Reset(&decoder.src)
+		}
 		if err != nil {
+			decoder.reader = nil
 			return nil, fmt.Errorf("Failed to initialize gzip reader: %s", err)
 		}
-		defer reader.Close()
 
-		return io.ReadAll(reader)
+		return readLimited(decoder.reader, limit)
+	}
+}
+
+// NewZstdDecompressor creates a zstd decompressor. Frames referencing a dictionary by its ID are decompressed with the
+// matching one of the given dictionaries, which are validated by creating the first decoder.
+func NewZstdDecompressor(dictionaries ...[]byte) (DecompressFunc, error) {
+	options := []zstd.DOption{zstd.WithDecoderConcurrency(1), zstd.WithDecoderMaxWindow(ZstdMaxWindow), zstd.WithDecoderDicts(dictionaries...)}
+
+	first, err := zstd.NewReader(nil, options...)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to initialize zstd decoder: %s", err)
+	}
+
+	// decoders are not safe for concurrent streaming, so every call gets its own one from the pool. Further decoders
+	// cannot fail with the options the first one was created with.
+	pool := &sync.Pool{
+		New: func() any {
+			decoder, _ := zstd.NewReader(nil, options...)
+			return decoder
+		},
+	}
+	pool.Put(first)
+
+	return func(b []byte, limit int) ([]byte, error) {
+		decoder := pool.Get().(*zstd.Decoder)
+		defer pool.Put(decoder)
+
+		if err := decoder.Reset(bytes.NewReader(b)); err != nil {
+			return nil, fmt.Errorf("Failed to initialize zstd reader: %s", err)
+		}
+
+		out, err := readLimited(decoder, limit)
+		if err != nil && !errors.Is(err, ErrDecompressionLimit) {
+			return nil, fmt.Errorf("Failed to decode zstd: %s", err)
+		}
+
+		return out, err
+	}, nil
+}
+
+// resettableDecoder is a zlib or flate reader, which can be reused for the next packet
+type resettableDecoder struct {
+	src    bytes.Reader
+	reader io.ReadCloser
+}
+
+func (d *resettableDecoder) reset(b []byte) error {
+	d.src.Reset(b)
+
+	// zlib readers implement an identical Resetter interface
+	return d.reader.(flate.Resetter).Reset(&d.src, nil)
+}
+
+func NewZlibDecompressor() DecompressFunc {
+	pool := &sync.Pool{
+		New: func() any {
+			return &resettableDecoder{}
+		},
+	}
+
+	return func(b []byte, limit int) ([]byte, error) {
+		decoder := pool.Get().(*resettableDecoder)
+		defer pool.Put(decoder)
+
+		var err error
+		if decoder.reader == nil {
+			decoder.src.Reset(b)
+			decoder.reader, err = zlib.NewReader(&decoder.src)
+		} else {
+			err = decoder.reset(b)
+		}
+		if err != nil {
+			decoder.reader = nil
+			return nil, fmt.Errorf("Failed to initialize zlib reader: %s", err)
+		}
+
+		return readLimited(decoder.reader, limit)
 	}
 }
 
-func NewCompressionWorker(decomp DecompressFunc, logger *logging.Logger, chIn <-chan []byte, chOut chan<- []byte) *compressionWorker {
-	return &compressionWorker{decomp, logger, chIn, chOut}
+func NewDeflateDecompressor() DecompressFunc {
+	pool := &sync.Pool{
+		New: func() any {
+			return &resettableDecoder{}
+		},
+	}
+
+	return func(b []byte, limit int) ([]byte, error) {
+		decoder := pool.Get().(*resettableDecoder)
+		defer pool.Put(decoder)
+
+		if decoder.reader == nil {
+			decoder.src.Reset(b)
+			decoder.reader = flate.NewReader(&decoder.src)
+		} else if err := decoder.reset(b); err != nil {
+			decoder.reader = nil
+			return nil, fmt.Errorf("Failed to initialize flate reader: %s", err)
+		}
+
+		out, err := readLimited(decoder.reader, limit)
+		if err != nil && !errors.Is(err, ErrDecompressionLimit) {
+			return nil, fmt.Errorf("Failed to inflate: %s", err)
+		}
+
+		return out, err
+	}
+}
+
+// readLimited reads into a pooled scratch buffer and returns an exactly sized copy, because the parsed messages keep
+// referencing the decompressed payload
+func readLimited(reader io.Reader, limit int) ([]byte, error) {
+	buf := getScratchBuffer()
+	defer releaseScratchBuffer(buf)
+
+	if limit > 0 {
+		// read a single byte more than allowed to detect exceeding data
+		reader = &io.LimitedReader{R: reader, N: int64(limit) + 1}
+	}
+
+	if _, err := buf.ReadFrom(reader); err != nil {
+		return nil, err
+	}
+
+	if limit > 0 && buf.Len() > limit {
+		return nil, fmt.Errorf("%w of %d bytes", ErrDecompressionLimit, limit)
+	}
+
+	return bytes.Clone(buf.Bytes()), nil
+}
+
+// LoadZstdDictionary reads a dictionary file as created by zstd --train or pirate-dict and returns its ID
+func LoadZstdDictionary(filename string) (uint32, []byte, error) {
+	dict, err := os.ReadFile(filename)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	inspected, err := zstd.InspectDictionary(dict)
+	if err != nil {
+		return 0, nil, fmt.Errorf("Invalid zstd dictionary %s: %s", filename, err)
+	}
+
+	if inspected.ID() == 0 {
+		return 0, nil, fmt.Errorf("Zstd dictionary %s has no ID", filename)
+	}
+
+	return inspected.ID(), dict, nil
+}
+
+// NewDecompressor returns the decompressor of a single format
+func NewDecompressor(compression string) (DecompressFunc, error) {
+	switch compression {
+	case CompressionPlain:
+		return NewPlainDecompressor(), nil
+	case CompressionGzip:
+		return NewGzipDecompressor(), nil
+	case CompressionZstd:
+		return NewZstdDecompressor()
+	case CompressionZlib:
+		return NewZlibDecompressor(), nil
+	case CompressionDeflate:
+		return NewDeflateDecompressor(), nil
+	}
+
+	return nil, fmt.Errorf("Unknown compression %q", compression)
+}
+
+// DetectCompression guesses the format by the magic bytes of gzip, zstd and zlib. Raw deflate streams have no magic
+// bytes, so everything else is assumed to be deflate, unless it looks like a plain text message.
+func DetectCompression(b []byte) string {
+	switch {
+	case bytes.HasPrefix(b, gzipMagic):
+		return CompressionGzip
+	case bytes.HasPrefix(b, zstdMagic):
+		return CompressionZstd
+	case isPlainText(b):
+		return CompressionPlain
+	case len(b) >= 2 && b[0]&0x0f == 8 && b[0]>>4 <= 7 && (uint16(b[0])<<8|uint16(b[1]))%31 == 0:
+		return CompressionZlib
+	}
+
+	return CompressionDeflate
+}
+
+// isPlainText checks the beginning of the payload for control characters and invalid UTF-8
+func isPlainText(b []byte) bool {
+	if len(b) > 64 {
+		b = b[:64]
+	}
+
+	for _, c := range b {
+		if c < 0x20 && c != '\n' && c != '\r' && c != '\t' {
+			return false
+		}
+	}
+
+	// the beginning may end within a multi-byte character
+	for i := 0; i < utf8.UTFMax && len(b) > 0; i++ {
+		if utf8.Valid(b) {
+			return true
+		}
+		b = b[:len(b)-1]
+	}
+
+	return len(b) == 0
+}
+
+// NewCompressionWorker creates a worker, which decompresses all packets with the given compression. With "auto",
+// the format is detected per packet. Senders of packets exceeding the limits are penalised in the rate limiter.
+// The zstd dictionaries are looked up by the dictionary ID in the frame header.
+func NewCompressionWorker(compression string, limits *DecompressionConfig, dictionaries map[uint32][]byte, limiter *IpLimiter, logger *logging.Logger, stats *MonitoringStats, chIn <-chan *Packet, chOut chan<- *Packet) (*compressionWorker, error) {
+	formats := []string{compression}
+	if compression == CompressionAuto {
+		formats = []string{CompressionPlain, CompressionGzip, CompressionZstd, CompressionZlib, CompressionDeflate}
+	}
+
+	dicts := make([][]byte, 0, len(dictionaries))
+	for _, dict := range dictionaries {
+		dicts = append(dicts, dict)
+	}
+
+	decompressors := make(map[string]DecompressFunc, len(formats))
+	for _, format := range formats {
+		var decompress DecompressFunc
+		var err error
+		if format == CompressionZstd {
+			decompress, err = NewZstdDecompressor(dicts...)
+		} else {
+			decompress, err = NewDecompressor(format)
+		}
+		if err != nil {
+			return nil, err
+		}
+		decompressors[format] = decompress
+	}
+
+	return &compressionWorker{compression, decompressors, limits, limiter, logger, stats, chIn, chOut}, nil
 }
 
 func (w *compressionWorker) Run(concurrency int) {
@@ -53,22 +340,66 @@ func (w *compressionWorker) Run(concurrency int) {
 }
 
 func (w *compressionWorker) run(wg *sync.WaitGroup) {
-	for in := range w.chIn {
-		out, err := w.decompress(in)
-		if err != nil {
-			w.logger.Warningf("[Decompressor] Failed to decompress: %s", err)
+	for packet := range w.chIn {
+		if err := w.decompress(packet); err != nil {
+			packet.Done(err)
 			continue
 		}
 
-		if w.logger.IsEnabledFor(logging.DEBUG) {
-			w.logger.Debugf("[Decompressor] Decompressed %d bytes to %d bytes", len(in), len(out))
-			for _, row := range bytes.Split(bytes.TrimSpace(out), []byte("\n")) {
-				w.logger.Debugf("[Decompressor] > %s", row)
+		w.chOut <- packet
+	}
+
+	wg.Done()
+}
+
+func (w *compressionWorker) decompress(packet *Packet) error {
+	in := packet.Payload
+
+	compression := w.compression
+	if compression == CompressionAuto {
+		compression = DetectCompression(in)
+	}
+
+	// the dictionary is checked against the project by the validator
+	dictionaryID := uint32(0)
+	if compression == CompressionZstd {
+		header := zstd.Header{}
+		if header.Decode(in) == nil {
+			dictionaryID = header.DictionaryID
+		}
+	}
+
+	out, err := w.decompressors[compression](in, w.limits.Limit(len(in)))
+
+	// the compressed payload is not referenced anymore, plain payloads are passed on as they are
+	if compression != CompressionPlain {
+		releasePacketBuffer(in)
+	}
+
+	if err != nil {
+		// penalise the peer by the same key it is rate limited by, which is not its IP for unix sockets
+		key := packet.limitKey()
+		if errors.Is(err, ErrDecompressionLimit) {
+			w.stats.IncDecompressionLimitExceeded()
+			if key != "" && w.limits.Penalty > 0 {
+				w.limiter.AllowKeyN(key, w.limits.Penalty)
 			}
 		}
 
-		w.chOut <- out
+		w.logger.Warningf("[Decompressor] Failed to decompress %s from %s: %s", compression, key, err)
+		return err
 	}
 
-	wg.Done()
+	if w.logger.IsEnabledFor(logging.DEBUG) {
+		w.logger.Debugf("[Decompressor] Decompressed %d bytes of %s to %d bytes", len(in), compression, len(out))
+		for _, row := range bytes.Split(bytes.TrimSpace(out), []byte("\n")) {
+			w.logger.Debugf("[Decompressor] > %s", row)
+		}
+	}
+
+	packet.Payload = out
+	packet.Compression = compression
+	packet.DictionaryID = dictionaryID
+
+	return nil
 }
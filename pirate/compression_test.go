@@ -0,0 +1,277 @@
+package pirate
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"github.com/klauspost/compress/dict"
+	"github.com/klauspost/compress/zstd"
+	"github.com/op/go-logging"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+var compressionTestMsg = []byte("project=awesome_client\nplatform=ios\n\nfps 30 1700000000\nmemory 1024 1700000000\n")
+
+func compressForTest(t testing.TB, compression string) []byte {
+	buf := &bytes.Buffer{}
+
+	var w io.WriteCloser
+	switch compression {
+	case CompressionPlain:
+		return compressionTestMsg
+	case CompressionGzip:
+		w = gzip.NewWriter(buf)
+	case CompressionZlib:
+		w = zlib.NewWriter(buf)
+	case CompressionDeflate:
+		w, _ = flate.NewWriter(buf, flate.DefaultCompression)
+	case CompressionZstd:
+		w, _ = zstd.NewWriter(buf)
+	}
+
+	_, err := w.Write(compressionTestMsg)
+	assert.Nil(t, err)
+	assert.Nil(t, w.Close())
+
+	return buf.Bytes()
+}
+
+func TestDecompressors(t *testing.T) {
+	for _, compression := range []string{CompressionPlain, CompressionGzip, CompressionZstd, CompressionZlib, CompressionDeflate} {
+		t.Run(compression, func(t *testing.T) {
+			decompress, err := NewDecompressor(compression)
+			assert.Nil(t, err)
+
+			out, err := decompress(compressForTest(t, compression), 0)
+
+			assert.Nil(t, err)
+			assert.Equal(t, compressionTestMsg, out)
+		})
+	}
+
+	for _, compression := range []string{CompressionGzip, CompressionZstd, CompressionZlib, CompressionDeflate} {
+		t.Run(compression+" exceeding limit", func(t *testing.T) {
+			decompress, _ := NewDecompressor(compression)
+
+			out, err := decompress(compressForTest(t, compression), len(compressionTestMsg)-1)
+
+			assert.Nil(t, out)
+			assert.ErrorIs(t, err, ErrDecompressionLimit)
+		})
+
+		t.Run(compression+" within limit", func(t *testing.T) {
+			decompress, _ := NewDecompressor(compression)
+
+			out, err := decompress(compressForTest(t, compression), len(compressionTestMsg))
+
+			assert.Nil(t, err)
+			assert.Equal(t, compressionTestMsg, out)
+		})
+	}
+
+	t.Run("unknown", func(t *testing.T) {
+		_, err := NewDecompressor("lz4")
+
+		assert.NotNil(t, err)
+	})
+}
+
+func TestDetectCompression(t *testing.T) {
+	for _, compression := range []string{CompressionPlain, CompressionGzip, CompressionZstd, CompressionZlib, CompressionDeflate} {
+		t.Run(compression, func(t *testing.T) {
+			assert.Equal(t, compression, DetectCompression(compressForTest(t, compression)))
+		})
+	}
+
+	t.Run("plain with multi-byte characters", func(t *testing.T) {
+		msg := bytes.Repeat([]byte("ü"), 40)
+
+		assert.Equal(t, CompressionPlain, DetectCompression(msg))
+	})
+}
+
+func TestDecompressionLimit(t *testing.T) {
+	t.Run("max size", func(t *testing.T) {
+		cfg := &DecompressionConfig{MaxSize: 1000, MaxRatio: 100}
+
+		assert.Equal(t, 1000, cfg.Limit(50))
+	})
+
+	t.Run("max ratio", func(t *testing.T) {
+		cfg := &DecompressionConfig{MaxSize: 1000, MaxRatio: 10}
+
+		assert.Equal(t, 500, cfg.Limit(50))
+	})
+
+	t.Run("unlimited", func(t *testing.T) {
+		cfg := &DecompressionConfig{}
+
+		assert.Equal(t, 0, cfg.Limit(50))
+	})
+}
+
+func TestDecompressionPenalty(t *testing.T) {
+	limits := &DecompressionConfig{MaxSize: 10, Penalty: 5}
+	limiter := NewIpLimiter(5, time.Minute)
+
+	w, err := NewCompressionWorker(CompressionGzip, limits, nil, limiter, newTestLogger(), NewMonitoringStats(), nil, nil)
+	assert.Nil(t, err)
+
+	// peers of unix sockets have no IP and are penalised by the key they are rate limited by
+	unixPacket := NewPacket(compressForTest(t, CompressionGzip), nil, time.Now())
+	unixPacket.LimitKey = "uid:1000"
+	assert.ErrorIs(t, w.decompress(unixPacket), ErrDecompressionLimit)
+
+	ipPacket := NewPacket(compressForTest(t, CompressionGzip), net.ParseIP("192.0.2.1"), time.Now())
+	assert.ErrorIs(t, w.decompress(ipPacket), ErrDecompressionLimit)
+
+	assert.False(t, limiter.AllowKey("uid:1000"))
+	assert.False(t, limiter.Allow(net.ParseIP("192.0.2.1")))
+	assert.True(t, limiter.AllowKey("uid:1001"))
+	assert.Equal(t, 2, w.stats.Reset()["decompression_limit_exceeded"])
+}
+
+func TestZstdDictionary(t *testing.T) {
+	samples := make([][]byte, 0, 100)
+	for i := 0; i < 100; i++ {
+		samples = append(samples, []byte(fmt.Sprintf("project=awesome_client; platform=ios;\nfps %d 1700000000\nmemory %d 1700000000\n", i, i*10)))
+	}
+
+	dictionary, err := dict.BuildZstdDict(samples, dict.Options{MaxDictSize: 4096, HashBytes: 6, ZstdDictID: 40000})
+	assert.Nil(t, err)
+
+	filename := filepath.Join(t.TempDir(), "pirate.dict")
+	assert.Nil(t, os.WriteFile(filename, dictionary, 0644))
+
+	id, loaded, err := LoadZstdDictionary(filename)
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(40000), id)
+	assert.Equal(t, dictionary, loaded)
+
+	encoder, err := zstd.NewWriter(nil, zstd.WithEncoderDict(dictionary))
+	assert.Nil(t, err)
+	payload := encoder.EncodeAll(compressionTestMsg, nil)
+
+	t.Run("with dictionary", func(t *testing.T) {
+		decompress, err := NewZstdDecompressor(dictionary)
+		assert.Nil(t, err)
+
+		out, err := decompress(payload, 0)
+
+		assert.Nil(t, err)
+		assert.Equal(t, compressionTestMsg, out)
+	})
+
+	t.Run("unknown dictionary", func(t *testing.T) {
+		decompress, err := NewZstdDecompressor()
+		assert.Nil(t, err)
+
+		_, err = decompress(payload, 0)
+
+		assert.NotNil(t, err)
+	})
+
+	t.Run("dictionary rejected by the decoder", func(t *testing.T) {
+		_, err := NewZstdDecompressor(compressionTestMsg)
+		assert.ErrorContains(t, err, "Failed to initialize zstd decoder")
+
+		_, err = NewCompressionWorker(CompressionZstd, &DecompressionConfig{}, map[uint32][]byte{1: compressionTestMsg}, nil, newTestLogger(), NewMonitoringStats(), nil, nil)
+		assert.NotNil(t, err)
+	})
+
+	t.Run("dictionary id of packet", func(t *testing.T) {
+		logger := logging.MustGetLogger("test")
+		logging.SetLevel(logging.ERROR, "test")
+		w, _ := NewCompressionWorker(CompressionAuto, &DecompressionConfig{}, map[uint32][]byte{id: dictionary}, nil, logger, NewMonitoringStats(), nil, nil)
+		packet := NewPacket(payload, nil, time.Time{})
+
+		assert.Nil(t, w.decompress(packet))
+		assert.Equal(t, CompressionZstd, packet.Compression)
+		assert.Equal(t, id, packet.DictionaryID)
+	})
+
+	t.Run("invalid dictionary", func(t *testing.T) {
+		assert.Nil(t, os.WriteFile(filename, compressionTestMsg, 0644))
+
+		_, _, err := LoadZstdDictionary(filename)
+
+		assert.NotNil(t, err)
+	})
+}
+
+func TestPacketBuffers(t *testing.T) {
+	t.Run("size classes", func(t *testing.T) {
+		assert.Equal(t, 512, cap(getPacketBuffer(1)))
+		assert.Equal(t, 512, cap(getPacketBuffer(512)))
+		assert.Equal(t, 1024, cap(getPacketBuffer(513)))
+		assert.Equal(t, UdpBufferSize, cap(getPacketBuffer(UdpBufferSize)))
+	})
+
+	t.Run("length", func(t *testing.T) {
+		buf := getPacketBuffer(100)
+		releasePacketBuffer(buf)
+
+		assert.Len(t, getPacketBuffer(200), 200)
+	})
+
+	t.Run("oversized", func(t *testing.T) {
+		buf := getPacketBuffer(UdpBufferSize + 1)
+
+		assert.Len(t, buf, UdpBufferSize+1)
+		releasePacketBuffer(buf)
+	})
+}
+
+func BenchmarkDecompressors(b *testing.B) {
+	for _, compression := range []string{CompressionGzip, CompressionZstd, CompressionZlib, CompressionDeflate} {
+		b.Run(compression, func(b *testing.B) {
+			decompress, _ := NewDecompressor(compression)
+			payload := compressForTest(b, compression)
+
+			b.ReportAllocs()
+			b.ResetTimer()
+
+			for i := 0; i < b.N; i++ {
+				if _, err := decompress(payload, 1024*1024); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkCompressionWorker covers the packet copy of the UDP server and the decompression of the packet
+func BenchmarkCompressionWorker(b *testing.B) {
+	logger := logging.MustGetLogger("benchmark")
+	logging.SetLevel(logging.ERROR, "benchmark")
+
+	limits := &DecompressionConfig{MaxSize: 1024 * 1024, MaxRatio: 100}
+	ip := net.ParseIP("127.0.0.1")
+
+	for _, compression := range []string{CompressionGzip, CompressionZstd, CompressionZlib, CompressionDeflate} {
+		b.Run(compression, func(b *testing.B) {
+			w, _ := NewCompressionWorker(compression, limits, nil, nil, logger, NewMonitoringStats(), nil, nil)
+			payload := compressForTest(b, compression)
+
+			b.ReportAllocs()
+			b.ResetTimer()
+
+			for i := 0; i < b.N; i++ {
+				buf := getPacketBuffer(len(payload))
+				copy(buf, payload)
+
+				if err := w.decompress(NewPacket(buf, ip, time.Time{})); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -1,34 +1,61 @@
 package pirate
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
 	"github.com/op/go-logging"
 	"gopkg.in/yaml.v2"
 	"os"
 	"regexp"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 )
 
 type Config struct {
-	UdpAddress         string           `yaml:"udp_address"`
-	GraphiteTarget     string           `yaml:"graphite_target"`
-	PerIpRateLimit     *RateLimitConfig `yaml:"per_ip_ratelimit"`
-	Gzip               bool             `yaml:"gzip"`
-	LogLevelStr        string           `yaml:"log_level"`
-	LogLevel           logging.Level    `yaml:"-"`
-	MonitoringEnabled  bool             `yaml:"monitoring_enabled"`
-	MonitoringPattern  string           `yaml:"monitoring_path"`
-	MonitoringTemplate *pathTemplate    `yaml:"-"`
+	UdpConfig          `yaml:",inline"`
+	UdpListeners       []*UdpListenerConfig `yaml:"udp_listeners"`
+	Http               *HttpConfig          `yaml:"http"`
+	Tcp                *TcpConfig           `yaml:"tcp"`
+	Unix               *UnixConfig          `yaml:"unix"`
+	GraphiteTarget     string               `yaml:"graphite_target"`
+	Outputs            []*OutputConfig      `yaml:"outputs"`
+	ProjectOutputs     []*OutputConfig      `yaml:"-"`
+	PerIpRateLimit     *RateLimitConfig     `yaml:"per_ip_ratelimit"`
+	WriterRetry        *RetryConfig         `yaml:"writer_retry"`
+	Spill              *SpillConfig         `yaml:"spill"`
+	Prometheus         *PrometheusConfig    `yaml:"prometheus"`
+	Gzip               bool                 `yaml:"gzip"`
+	Compression        string               `yaml:"compression"`
+	Decompression      *DecompressionConfig `yaml:"decompression"`
+	ZstdDictionaries   map[uint32][]byte    `yaml:"-"`
+	ShutdownTimeout    time.Duration        `yaml:"shutdown_timeout"`
+	AdminAddress       string               `yaml:"admin_address"`
+	LogLevelStr        string               `yaml:"log_level"`
+	LogLevel           logging.Level        `yaml:"-"`
+	MonitoringEnabled  bool                 `yaml:"monitoring_enabled"`
+	MonitoringPattern  string               `yaml:"monitoring_path"`
+	MonitoringTemplate *pathTemplate        `yaml:"-"`
 	Projects           map[string]*ProjectConfig
 }
 
 type ProjectConfig struct {
-	GraphitePattern  string                    `yaml:"graphite_path"`
-	GraphiteTemplate *pathTemplate             `yaml:"-"`
-	Metrics          map[string]*MetricConfig  `yaml:"metrics"`
-	Attributes       map[string]string         `yaml:"attributes"`
-	AttributesRegex  map[string]*regexp.Regexp `yaml:"-"`
+	GraphitePattern        string                    `yaml:"graphite_path"`
+	GraphiteTarget         string                    `yaml:"graphite_target"`
+	GraphiteTags           []string                  `yaml:"graphite_tags"`
+	PrometheusLabels       []string                  `yaml:"prometheus_labels"`
+	Compression            []string                  `yaml:"compression"`
+	CompressionSet         map[string]bool           `yaml:"-"`
+	ZstdDictionaries       []string                  `yaml:"zstd_dictionaries"`
+	ZstdDictionaryIDs      map[uint32]bool           `yaml:"-"`
+	LenientParsing         bool                      `yaml:"lenient_parsing"`
+	AllowMissingTimestamps bool                      `yaml:"allow_missing_timestamps"`
+	GraphiteTemplate       *pathTemplate             `yaml:"-"`
+	Metrics                map[string]*MetricConfig  `yaml:"metrics"`
+	Attributes             map[string]string         `yaml:"attributes"`
+	AttributesRegex        map[string]*regexp.Regexp `yaml:"-"`
 }
 
 type MetricConfig struct {
@@ -36,6 +63,95 @@ type MetricConfig struct {
 	GraphiteTemplate *pathTemplate `yaml:"-"`
 	Min              float64       `yaml:"min"`
 	Max              float64       `yaml:"max"`
+	AllowNaN         bool          `yaml:"allow_nan"`
+	AllowInf         bool          `yaml:"allow_inf"`
+	PrometheusType   string        `yaml:"prometheus_type"`
+}
+
+type OutputConfig struct {
+	Name           string         `yaml:"name"`
+	Target         string         `yaml:"target"`
+	Projects       []string       `yaml:"projects"`
+	PathPattern    string         `yaml:"path_regex"`
+	PathRegex      *regexp.Regexp `yaml:"-"`
+	DeadLetterFile string         `yaml:"dead_letter_file"`
+	BlockWhenFull  bool           `yaml:"block_when_full"`
+}
+
+type RetryConfig struct {
+	MaxAttempts    int           `yaml:"max_attempts"`
+	InitialBackoff time.Duration `yaml:"initial_backoff"`
+	MaxBackoff     time.Duration `yaml:"max_backoff"`
+	DeadLetterFile string        `yaml:"dead_letter_file"`
+}
+
+type SpillConfig struct {
+	Enabled     bool          `yaml:"enabled"`
+	Directory   string        `yaml:"directory"`
+	SegmentSize int64         `yaml:"segment_size"`
+	MaxSize     int64         `yaml:"max_size"`
+	MaxAge      time.Duration `yaml:"max_age"`
+}
+
+type PrometheusConfig struct {
+	Enabled    bool          `yaml:"enabled"`
+	Address    string        `yaml:"address"`
+	Path       string        `yaml:"path"`
+	Staleness  time.Duration `yaml:"staleness"`
+	Timestamps bool          `yaml:"timestamps"`
+}
+
+type UdpConfig struct {
+	UdpAddress       string `yaml:"udp_address"`
+	UdpSockets       int    `yaml:"udp_sockets"`
+	UdpBatchSize     int    `yaml:"udp_batch_size"`
+	UdpReceiveBuffer int    `yaml:"udp_receive_buffer"`
+}
+
+type UdpListenerConfig struct {
+	Address       string          `yaml:"address"`
+	Projects      []string        `yaml:"projects"`
+	ProjectSet    map[string]bool `yaml:"-"`
+	MessageFormat string          `yaml:"message_format"`
+}
+
+type HttpConfig struct {
+	Enabled       bool   `yaml:"enabled"`
+	Address       string `yaml:"address"`
+	Path          string `yaml:"path"`
+	MaxBodySize   int64  `yaml:"max_body_size"`
+	CorsOrigin    string `yaml:"cors_origin"`
+	MessageFormat string `yaml:"message_format"`
+}
+
+type StreamConfig struct {
+	Framing        string        `yaml:"framing"`
+	MaxConnections int           `yaml:"max_connections"`
+	MaxMessageSize int           `yaml:"max_message_size"`
+	IdleTimeout    time.Duration `yaml:"idle_timeout"`
+	MessageFormat  string        `yaml:"message_format"`
+}
+
+type TcpConfig struct {
+	Enabled      bool   `yaml:"enabled"`
+	Address      string `yaml:"address"`
+	StreamConfig `yaml:",inline"`
+}
+
+type UnixConfig struct {
+	DatagramPath string      `yaml:"datagram_path"`
+	StreamPath   string      `yaml:"stream_path"`
+	ModeStr      string      `yaml:"mode"`
+	Mode         os.FileMode `yaml:"-"`
+	Owner        string      `yaml:"owner"`
+	Group        string      `yaml:"group"`
+	StreamConfig `yaml:",inline"`
+}
+
+type DecompressionConfig struct {
+	MaxSize  int `yaml:"max_size"`
+	MaxRatio int `yaml:"max_ratio"`
+	Penalty  int `yaml:"penalty"`
 }
 
 type RateLimitConfig struct {
@@ -44,18 +160,73 @@ type RateLimitConfig struct {
 	Interval time.Duration `yaml:"interval"`
 }
 
+var (
+	outputNameRegexp    = regexp.MustCompile(`^[a-z0-9_]+$`)
+	invalidOutputRegexp = regexp.MustCompile(`[^a-z0-9_]`)
+)
+
+var defaultStreamConfig = StreamConfig{
+	Framing:        FramingLength,
+	MaxConnections: 100,
+	MaxMessageSize: 1024 * 1024,
+	IdleTimeout:    1 * time.Minute,
+}
+
 var DefaultConfig = Config{
-	UdpAddress:        "0.0.0.0:33333",
+	UdpConfig: UdpConfig{
+		UdpAddress:   "0.0.0.0:33333",
+		UdpSockets:   1,
+		UdpBatchSize: 1,
+	},
 	GraphiteTarget:    "tcp://127.0.0.1:3002",
 	MonitoringEnabled: true,
 	MonitoringPattern: "pirate.{metric.name}",
 	Gzip:              true,
+	ShutdownTimeout:   10 * time.Second,
 	LogLevelStr:       "info",
+	Http: &HttpConfig{
+		Enabled:     false,
+		Address:     "0.0.0.0:33333",
+		Path:        "/",
+		MaxBodySize: UdpBufferSize,
+		CorsOrigin:  "*",
+	},
+	Tcp: &TcpConfig{
+		Enabled:      false,
+		Address:      "0.0.0.0:33334",
+		StreamConfig: defaultStreamConfig,
+	},
+	Unix: &UnixConfig{
+		ModeStr:      "0660",
+		StreamConfig: defaultStreamConfig,
+	},
+	Decompression: &DecompressionConfig{
+		MaxSize:  1024 * 1024,
+		MaxRatio: 100,
+	},
 	PerIpRateLimit: &RateLimitConfig{
 		Enabled:  true,
 		Amount:   100,
 		Interval: 1 * time.Minute,
 	},
+	WriterRetry: &RetryConfig{
+		MaxAttempts:    5,
+		InitialBackoff: 100 * time.Millisecond,
+		MaxBackoff:     5 * time.Second,
+	},
+	Spill: &SpillConfig{
+		Enabled:     false,
+		Directory:   "/var/lib/pirate/spill",
+		SegmentSize: 4 * 1024 * 1024,
+		MaxSize:     1024 * 1024 * 1024,
+		MaxAge:      3 * time.Hour,
+	},
+	Prometheus: &PrometheusConfig{
+		Enabled:   false,
+		Address:   "0.0.0.0:9337",
+		Path:      "/metrics",
+		Staleness: 5 * time.Minute,
+	},
 	Projects: make(map[string]*ProjectConfig),
 }
 
@@ -65,7 +236,8 @@ func LoadConfig(filename string) (*Config, error) {
 		return nil, fmt.Errorf("Failed to load config file from %s: %s", filename, err)
 	}
 
-	cfg := &DefaultConfig
+	// start with a copy of the defaults, so that DefaultConfig stays untouched on (re)loads
+	cfg := DefaultConfig.copy()
 	if err := yaml.Unmarshal(content, cfg); err != nil {
 		return nil, fmt.Errorf("Failed to parse configuration file: %s", err)
 	}
@@ -78,7 +250,12 @@ func LoadConfig(filename string) (*Config, error) {
 	}
 
 	// initialize regexps and templates
+	cfg.ZstdDictionaries = make(map[uint32][]byte)
 	for pid, project := range cfg.Projects {
+		if project == nil {
+			return nil, fmt.Errorf(`Missing definition for "projects.%s"`, pid)
+		}
+
 		// initialize graphite path templates
 		if project.GraphiteTemplate, err = ParsePathTemplate([]byte(project.GraphitePattern)); err != nil {
 			return nil, fmt.Errorf(`Invalid path for "projects.%s.graphite_path": %s`, pid, err)
@@ -92,8 +269,63 @@ func LoadConfig(filename string) (*Config, error) {
 			}
 		}
 
+		// graphite tags must refer to known attributes
+		for _, tag := range project.GraphiteTags {
+			if _, exists := project.Attributes[tag]; !exists && tag != "project" {
+				return nil, fmt.Errorf(`Unknown attribute "%s" in "projects.%s.graphite_tags"`, tag, pid)
+			}
+		}
+		sort.Strings(project.GraphiteTags)
+
+		// prometheus labels must refer to known attributes
+		for _, label := range project.PrometheusLabels {
+			if _, exists := project.Attributes[label]; !exists {
+				return nil, fmt.Errorf(`Unknown attribute "%s" in "projects.%s.prometheus_labels"`, label, pid)
+			}
+		}
+		sort.Strings(project.PrometheusLabels)
+
+		// restrict the accepted compressions
+		if len(project.Compression) > 0 {
+			project.CompressionSet = make(map[string]bool, len(project.Compression))
+			for _, compression := range project.Compression {
+				if _, err := NewDecompressor(compression); err != nil {
+					return nil, fmt.Errorf(`Invalid value for "projects.%s.compression": %s`, pid, err)
+				}
+				project.CompressionSet[compression] = true
+			}
+		}
+
+		// load the zstd dictionaries, which are selected by the dictionary ID in the frame header
+		if len(project.ZstdDictionaries) > 0 {
+			project.ZstdDictionaryIDs = make(map[uint32]bool, len(project.ZstdDictionaries))
+			for _, filename := range project.ZstdDictionaries {
+				id, dict, err := LoadZstdDictionary(filename)
+				if err != nil {
+					return nil, fmt.Errorf(`Invalid value for "projects.%s.zstd_dictionaries": %s`, pid, err)
+				}
+
+				if other, exists := cfg.ZstdDictionaries[id]; exists && !bytes.Equal(other, dict) {
+					return nil, fmt.Errorf(`Invalid value for "projects.%s.zstd_dictionaries": ID %d of %s is used by another dictionary`, pid, id, filename)
+				}
+
+				cfg.ZstdDictionaries[id] = dict
+				project.ZstdDictionaryIDs[id] = true
+			}
+		}
+
 		// initialize graphite path templates for metrics
 		for mid, metric := range project.Metrics {
+			if metric == nil {
+				return nil, fmt.Errorf(`Missing definition for "projects.%s.metrics.%s"`, pid, mid)
+			}
+
+			switch metric.PrometheusType {
+			case "", PrometheusGauge, PrometheusCounter:
+			default:
+				return nil, fmt.Errorf(`Invalid value for "projects.%s.metrics.%s.prometheus_type": must be "gauge" or "counter"`, pid, mid)
+			}
+
 			// use same template from project, if not overridden
 			if metric.GraphitePattern == "" {
 				metric.GraphitePattern = project.GraphitePattern
@@ -107,6 +339,188 @@ func LoadConfig(filename string) (*Config, error) {
 		}
 	}
 
+	if cfg.WriterRetry.MaxAttempts < 1 {
+		return nil, errors.New(`Invalid value for "writer_retry.max_attempts": must be at least 1`)
+	}
+
+	if cfg.WriterRetry.InitialBackoff <= 0 || cfg.WriterRetry.MaxBackoff < cfg.WriterRetry.InitialBackoff {
+		return nil, errors.New(`Invalid backoff for "writer_retry": initial_backoff must be positive and not exceed max_backoff`)
+	}
+
+	if cfg.Spill.Enabled {
+		if cfg.Spill.Directory == "" {
+			return nil, errors.New(`Missing value for "spill.directory"`)
+		}
+
+		if cfg.Spill.SegmentSize <= 0 || cfg.Spill.MaxSize < cfg.Spill.SegmentSize {
+			return nil, errors.New(`Invalid sizes for "spill": segment_size must be positive and not exceed max_size`)
+		}
+
+		if cfg.Spill.MaxAge <= 0 {
+			return nil, errors.New(`Invalid value for "spill.max_age": must be positive`)
+		}
+	}
+
+	if cfg.Http.Enabled && (cfg.Http.MaxBodySize <= 0 || !strings.HasPrefix(cfg.Http.Path, "/")) {
+		return nil, errors.New(`Invalid "http" config: max_body_size must be positive and path must start with "/"`)
+	}
+
+	if cfg.UdpSockets <= 0 || cfg.UdpBatchSize <= 0 || cfg.UdpReceiveBuffer < 0 {
+		return nil, errors.New(`Invalid UDP config: udp_sockets and udp_batch_size must be positive, udp_receive_buffer must not be negative`)
+	}
+
+	// the compression replaces the gzip flag
+	if cfg.Compression == "" {
+		cfg.Compression = CompressionPlain
+		if cfg.Gzip {
+			cfg.Compression = CompressionGzip
+		}
+	}
+
+	if _, err := NewDecompressor(cfg.Compression); err != nil && cfg.Compression != CompressionAuto {
+		return nil, fmt.Errorf(`Invalid value for "compression": %s`, err)
+	}
+
+	if cfg.Decompression.MaxSize < 0 || cfg.Decompression.MaxRatio < 0 || cfg.Decompression.Penalty < 0 {
+		return nil, errors.New(`Invalid "decompression" config: max_size, max_ratio and penalty must not be negative`)
+	}
+
+	// udp_address has a default, so whether it is set is checked on the file, it would be ignored with udp_listeners
+	var explicit struct {
+		UdpAddress *string `yaml:"udp_address"`
+	}
+	if err := yaml.Unmarshal(content, &explicit); err == nil && explicit.UdpAddress != nil && len(cfg.UdpListeners) > 0 {
+		return nil, errors.New(`"udp_address" must not be set with "udp_listeners", add it as a listener instead`)
+	}
+
+	// without explicit listeners, all projects are accepted on the udp_address
+	if len(cfg.UdpListeners) == 0 {
+		cfg.UdpListeners = []*UdpListenerConfig{{Address: cfg.UdpAddress}}
+	}
+
+	for i, listener := range cfg.UdpListeners {
+		if listener == nil || listener.Address == "" {
+			return nil, fmt.Errorf(`Missing address for "udp_listeners.%d"`, i)
+		}
+
+		if err := validateMessageFormat(fmt.Sprintf("udp_listeners.%d", i), &listener.MessageFormat); err != nil {
+			return nil, err
+		}
+
+		if len(listener.Projects) == 0 {
+			continue
+		}
+
+		listener.ProjectSet = make(map[string]bool, len(listener.Projects))
+		for _, pid := range listener.Projects {
+			if _, exists := cfg.Projects[pid]; !exists {
+				return nil, fmt.Errorf(`Unknown project "%s" in "udp_listeners.%d"`, pid, i)
+			}
+			listener.ProjectSet[pid] = true
+		}
+	}
+
+	if err := validateMessageFormat("http", &cfg.Http.MessageFormat); err != nil {
+		return nil, err
+	}
+
+	if err := validateMessageFormat("tcp", &cfg.Tcp.MessageFormat); err != nil {
+		return nil, err
+	}
+
+	if err := validateMessageFormat("unix", &cfg.Unix.MessageFormat); err != nil {
+		return nil, err
+	}
+
+	if cfg.Tcp.Enabled {
+		if err := cfg.Tcp.StreamConfig.validate("tcp"); err != nil {
+			return nil, err
+		}
+	}
+
+	if cfg.Unix.StreamPath != "" {
+		if err := cfg.Unix.StreamConfig.validate("unix"); err != nil {
+			return nil, err
+		}
+	}
+
+	mode, err := strconv.ParseUint(cfg.Unix.ModeStr, 8, 32)
+	if err != nil || mode > 0777 {
+		return nil, fmt.Errorf(`Invalid value for "unix.mode": %q is no octal file mode`, cfg.Unix.ModeStr)
+	}
+	cfg.Unix.Mode = os.FileMode(mode)
+
+	if cfg.Prometheus.Enabled && (cfg.Prometheus.Staleness <= 0 || !strings.HasPrefix(cfg.Prometheus.Path, "/")) {
+		return nil, errors.New(`Invalid "prometheus" config: staleness must be positive and path must start with "/"`)
+	}
+
+	if len(cfg.Outputs) > 0 && cfg.WriterRetry.DeadLetterFile != "" {
+		return nil, errors.New(`Invalid "writer_retry.dead_letter_file": use the "dead_letter_file" of the outputs instead`)
+	}
+
+	// without explicit outputs, everything is sent to the graphite target
+	if len(cfg.Outputs) == 0 {
+		cfg.Outputs = []*OutputConfig{{Name: "default", Target: cfg.GraphiteTarget, DeadLetterFile: cfg.WriterRetry.DeadLetterFile}}
+	}
+
+	outputNames := make(map[string]bool)
+	for i, output := range cfg.Outputs {
+		if output == nil || output.Target == "" {
+			return nil, fmt.Errorf(`Missing target for "outputs.%d"`, i)
+		}
+
+		if output.Name == "" {
+			output.Name = fmt.Sprintf("output%d", i)
+		}
+
+		if !outputNameRegexp.MatchString(output.Name) || outputNames[output.Name] {
+			return nil, fmt.Errorf(`Invalid name for "outputs.%d": must be unique and consist of [a-z0-9_]`, i)
+		}
+		outputNames[output.Name] = true
+
+		for _, pid := range output.Projects {
+			if _, exists := cfg.Projects[pid]; !exists {
+				return nil, fmt.Errorf(`Unknown project "%s" in "outputs.%s.projects"`, pid, output.Name)
+			}
+		}
+
+		if output.PathPattern != "" {
+			if output.PathRegex, err = regexp.Compile(output.PathPattern); err != nil {
+				return nil, fmt.Errorf(`Invalid regexp for "outputs.%s.path_regex": %s`, output.Name, err)
+			}
+		}
+	}
+
+	// projects with their own graphite target get one output per distinct target
+	pids := make([]string, 0, len(cfg.Projects))
+	for pid := range cfg.Projects {
+		pids = append(pids, pid)
+	}
+	sort.Strings(pids)
+
+	projectOutputs := make(map[string]*OutputConfig)
+	for _, pid := range pids {
+		target := cfg.Projects[pid].GraphiteTarget
+		if target == "" {
+			continue
+		}
+
+		if output, exists := projectOutputs[target]; exists {
+			output.Projects = append(output.Projects, pid)
+			continue
+		}
+
+		name := "project_" + invalidOutputRegexp.ReplaceAllString(strings.ToLower(pid), "_")
+		for i := 2; outputNames[name]; i++ {
+			name = fmt.Sprintf("project_%s_%d", invalidOutputRegexp.ReplaceAllString(strings.ToLower(pid), "_"), i)
+		}
+		outputNames[name] = true
+
+		output := &OutputConfig{Name: name, Target: target, Projects: []string{pid}}
+		projectOutputs[target] = output
+		cfg.ProjectOutputs = append(cfg.ProjectOutputs, output)
+	}
+
 	// initialize log level
 	cfg.LogLevel = logging.WARNING
 	if cfg.LogLevelStr != "" {
@@ -119,14 +533,144 @@ func LoadConfig(filename string) (*Config, error) {
 	return cfg, nil
 }
 
+// Limit returns the maximum decompressed size of a payload with the given size, 0 means unlimited
+func (cfg *DecompressionConfig) Limit(size int) int {
+	limit := cfg.MaxSize
+	if cfg.MaxRatio > 0 && (limit == 0 || size*cfg.MaxRatio < limit) {
+		limit = size * cfg.MaxRatio
+	}
+
+	return limit
+}
+
+// Allows reports whether messages of the project are accepted on the listener
+func (cfg *UdpListenerConfig) Allows(pid string) bool {
+	return cfg.ProjectSet == nil || cfg.ProjectSet[pid]
+}
+
+// validateMessageFormat checks the message format of a listener, which detects the format by default
+func validateMessageFormat(section string, format *string) error {
+	switch *format {
+	case "":
+		*format = MessageFormatAuto
+	case MessageFormatAuto, MessageFormatLine, MessageFormatJSON:
+	default:
+		return fmt.Errorf(`Invalid value for "%s.message_format": must be %q, %q or %q`, section, MessageFormatAuto, MessageFormatLine, MessageFormatJSON)
+	}
+
+	return nil
+}
+
+func (cfg *StreamConfig) validate(section string) error {
+	if cfg.Framing != FramingLength && cfg.Framing != FramingBlankLine {
+		return fmt.Errorf(`Invalid value for "%s.framing": must be %q or %q`, section, FramingLength, FramingBlankLine)
+	}
+
+	if cfg.MaxConnections <= 0 || cfg.MaxMessageSize <= 0 || cfg.IdleTimeout <= 0 {
+		return fmt.Errorf(`Invalid "%s" config: max_connections, max_message_size and idle_timeout must be positive`, section)
+	}
+
+	return nil
+}
+
+func (cfg Config) copy() *Config {
+	if cfg.Http != nil {
+		http := *cfg.Http
+		cfg.Http = &http
+	}
+
+	if cfg.Decompression != nil {
+		decompression := *cfg.Decompression
+		cfg.Decompression = &decompression
+	}
+
+	if cfg.Tcp != nil {
+		tcp := *cfg.Tcp
+		cfg.Tcp = &tcp
+	}
+
+	if cfg.Unix != nil {
+		unix := *cfg.Unix
+		cfg.Unix = &unix
+	}
+
+	if cfg.PerIpRateLimit != nil {
+		limit := *cfg.PerIpRateLimit
+		cfg.PerIpRateLimit = &limit
+	}
+
+	if cfg.WriterRetry != nil {
+		retry := *cfg.WriterRetry
+		cfg.WriterRetry = &retry
+	}
+
+	if cfg.Spill != nil {
+		spill := *cfg.Spill
+		cfg.Spill = &spill
+	}
+
+	if cfg.Prometheus != nil {
+		prometheus := *cfg.Prometheus
+		cfg.Prometheus = &prometheus
+	}
+
+	projects := make(map[string]*ProjectConfig, len(cfg.Projects))
+	for pid, project := range cfg.Projects {
+		projects[pid] = project
+	}
+	cfg.Projects = projects
+
+	return &cfg
+}
+
 func (cfg *Config) Log(logger *logging.Logger) {
-	logger.Infof("[Config] UDP Address: %s", cfg.UdpAddress)
-	logger.Infof("[Config] Graphite Target: %s", cfg.GraphiteTarget)
+	logger.Infof("[Config] UDP Listeners: [sockets=%d batch_size=%d receive_buffer=%d]", cfg.UdpSockets, cfg.UdpBatchSize, cfg.UdpReceiveBuffer)
+	for _, listener := range cfg.UdpListeners {
+		logger.Infof("[Config]   - %s [projects=%v message_format=%s]", listener.Address, listener.Projects, listener.MessageFormat)
+	}
+	if cfg.Http.Enabled {
+		logger.Infof("[Config] HTTP Address: %s%s [message_format=%s]", cfg.Http.Address, cfg.Http.Path, cfg.Http.MessageFormat)
+	}
+	if cfg.Tcp.Enabled {
+		logger.Infof("[Config] TCP Address: %s [framing=%s max_connections=%d idle_timeout=%s message_format=%s]", cfg.Tcp.Address, cfg.Tcp.Framing, cfg.Tcp.MaxConnections, cfg.Tcp.IdleTimeout, cfg.Tcp.MessageFormat)
+	}
+	if cfg.Unix.DatagramPath != "" {
+		logger.Infof("[Config] Unix Datagram Socket: %s [mode=%s owner=%s group=%s message_format=%s]", cfg.Unix.DatagramPath, cfg.Unix.Mode, cfg.Unix.Owner, cfg.Unix.Group, cfg.Unix.MessageFormat)
+	}
+	if cfg.Unix.StreamPath != "" {
+		logger.Infof("[Config] Unix Stream Socket: %s [mode=%s owner=%s group=%s framing=%s message_format=%s]", cfg.Unix.StreamPath, cfg.Unix.Mode, cfg.Unix.Owner, cfg.Unix.Group, cfg.Unix.Framing, cfg.Unix.MessageFormat)
+	}
+	logger.Infof("[Config] Outputs:")
+	for _, output := range cfg.Outputs {
+		logger.Infof("[Config]   - %s [target=%s projects=%v path_regex=%s]", output.Name, output.Target, output.Projects, output.PathPattern)
+	}
+	for _, output := range cfg.ProjectOutputs {
+		logger.Infof("[Config]   - %s [target=%s projects=%v]", output.Name, output.Target, output.Projects)
+	}
+	if cfg.AdminAddress != "" {
+		logger.Infof("[Config] Admin Address: %s", cfg.AdminAddress)
+	}
+	if cfg.Prometheus.Enabled {
+		logger.Infof("[Config] Prometheus Exporter: %s%s [staleness=%s]", cfg.Prometheus.Address, cfg.Prometheus.Path, cfg.Prometheus.Staleness)
+	}
+	logger.Infof("[Config] Compression: %s [max_size=%d max_ratio=%d penalty=%d zstd_dictionaries=%d]", cfg.Compression, cfg.Decompression.MaxSize, cfg.Decompression.MaxRatio, cfg.Decompression.Penalty, len(cfg.ZstdDictionaries))
+	logger.Infof("[Config] Shutdown Timeout: %s", cfg.ShutdownTimeout)
+	logger.Infof("[Config] Writer Retry: %d attempts, backoff %s to %s", cfg.WriterRetry.MaxAttempts, cfg.WriterRetry.InitialBackoff, cfg.WriterRetry.MaxBackoff)
+	if cfg.WriterRetry.DeadLetterFile != "" {
+		logger.Infof("[Config] Dead Letter File: %s", cfg.WriterRetry.DeadLetterFile)
+	}
+	if cfg.Spill.Enabled {
+		logger.Infof("[Config] Spill Queue: %s [max_size=%d max_age=%s]", cfg.Spill.Directory, cfg.Spill.MaxSize, cfg.Spill.MaxAge)
+	}
 	logger.Infof("[Config] UDP Rate Limit: %d metrics per %s per IP", cfg.PerIpRateLimit.Amount, cfg.PerIpRateLimit.Interval)
 	logger.Infof("[Config] Projects:")
 
 	for pid, project := range cfg.Projects {
-		logger.Infof("[Config]   - %s", pid)
+		if len(project.GraphiteTags) > 0 {
+			logger.Infof("[Config]   - %s [tags=%v]", pid, project.GraphiteTags)
+		} else {
+			logger.Infof("[Config]   - %s", pid)
+		}
 
 		for mid, metric := range project.Metrics {
 			logger.Infof("[Config]     - %s [min=%.0f max=%.0f path=%s]", mid, metric.Min, metric.Max, metric.GraphitePattern)
@@ -0,0 +1,74 @@
+package pirate
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+const udpListenerTestProjects = `
+projects:
+  client:
+    graphite_path: client.{metric.name}
+    metrics:
+      fps: {min: 0, max: 100}
+  backend:
+    graphite_path: backend.{metric.name}
+    metrics:
+      fps: {min: 0, max: 100}
+`
+
+func TestUdpListeners(t *testing.T) {
+	t.Run("default", func(t *testing.T) {
+		cfg, err := loadTestConfig(t, `udp_address: 127.0.0.1:33333`+udpListenerTestProjects)
+
+		assert.Nil(t, err)
+		assert.Len(t, cfg.UdpListeners, 1)
+		assert.Equal(t, "127.0.0.1:33333", cfg.UdpListeners[0].Address)
+		assert.True(t, cfg.UdpListeners[0].Allows("client"))
+	})
+
+	t.Run("listeners", func(t *testing.T) {
+		cfg, err := loadTestConfig(t, `
+udp_listeners:
+  - address: 0.0.0.0:33333
+    projects: [client]
+  - address: 10.0.0.1:33334
+`+udpListenerTestProjects)
+
+		assert.Nil(t, err)
+		assert.Len(t, cfg.UdpListeners, 2)
+		assert.True(t, cfg.UdpListeners[0].Allows("client"))
+		assert.False(t, cfg.UdpListeners[0].Allows("backend"))
+		assert.True(t, cfg.UdpListeners[1].Allows("backend"), "Listeners without projects accept all projects")
+	})
+
+	t.Run("udp_address with listeners", func(t *testing.T) {
+		// even the default address would be ignored silently
+		_, err := loadTestConfig(t, `
+udp_address: 0.0.0.0:33333
+udp_listeners:
+  - address: 10.0.0.1:33334
+`+udpListenerTestProjects)
+
+		assert.ErrorContains(t, err, `"udp_address" must not be set with "udp_listeners"`)
+	})
+
+	t.Run("unknown project", func(t *testing.T) {
+		_, err := loadTestConfig(t, `
+udp_listeners:
+  - address: 10.0.0.1:33334
+    projects: [unknown]
+`+udpListenerTestProjects)
+
+		assert.ErrorContains(t, err, `Unknown project "unknown" in "udp_listeners.0"`)
+	})
+
+	t.Run("missing address", func(t *testing.T) {
+		_, err := loadTestConfig(t, `
+udp_listeners:
+  - projects: [client]
+`+udpListenerTestProjects)
+
+		assert.ErrorContains(t, err, `Missing address for "udp_listeners.0"`)
+	})
+}
@@ -0,0 +1,156 @@
+package pirate
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"github.com/op/go-logging"
+	"os"
+	"sync"
+)
+
+// DeadLetterFile keeps metrics, which could not be written, in the line format of the spill queue, so that replayed
+// metrics still have their original name, project and attributes for non-Graphite targets as well as their sub-second
+// timestamp
+type DeadLetterFile struct {
+	filename string
+	file     *os.File
+	logger   *logging.Logger
+	stats    *MonitoringStats
+	mu       sync.Mutex
+	replayMu sync.Mutex
+}
+
+func NewDeadLetterFile(filename string, logger *logging.Logger, stats *MonitoringStats) (*DeadLetterFile, error) {
+	file, err := os.OpenFile(filename, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to open dead letter file %s: %s", filename, err)
+	}
+
+	return &DeadLetterFile{filename: filename, file: file, logger: logger, stats: stats}, nil
+}
+
+func (d *DeadLetterFile) Write(m *Metric) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if _, err := d.file.Write(spillLine(m)); err != nil {
+		return fmt.Errorf("Failed to write to dead letter file %s: %s", d.filename, err)
+	}
+
+	d.stats.IncMetricsDeadLettered()
+
+	return nil
+}
+
+// Replay writes the dead-lettered metrics by the given write function until it fails. The failed metric and all
+// following ones stay in the dead letter file, so that a target, which is still down, is not hit by every metric. The
+// metrics are moved to a separate replay file first, so that metrics can still be dead-lettered while the replay writes
+// to the target and none get lost if the replay is interrupted.
+func (d *DeadLetterFile) Replay(write func(m *Metric) error) (int, error) {
+	d.replayMu.Lock()
+	defer d.replayMu.Unlock()
+
+	content, err := d.takeForReplay()
+	if err != nil {
+		return 0, err
+	}
+
+	var remaining []byte
+	var writeErr error
+	replayed := 0
+	offset := 0
+
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		start := offset
+		offset += len(line) + 1
+
+		metric, ok := parseSpillLine(line)
+		if !ok {
+			d.logger.Warningf("[DeadLetter] Skipping malformed line: %s", line)
+			continue
+		}
+
+		if writeErr = write(metric); writeErr != nil {
+			remaining = content[start:]
+			break
+		}
+
+		replayed++
+		d.stats.IncMetricsReplayed()
+	}
+
+	if len(remaining) > 0 && remaining[len(remaining)-1] != '\n' {
+		remaining = append(remaining, '\n')
+	}
+
+	d.mu.Lock()
+	_, err = d.file.Write(remaining)
+	d.mu.Unlock()
+
+	if err != nil {
+		return replayed, fmt.Errorf("Failed to write to dead letter file %s: %s", d.filename, err)
+	}
+
+	if err := os.Remove(d.replayFilename()); err != nil {
+		return replayed, fmt.Errorf("Failed to remove replay file %s: %s", d.replayFilename(), err)
+	}
+
+	if writeErr != nil {
+		return replayed, fmt.Errorf("Stopped replay after %d metrics, %d bytes stay in the dead letter file: %s", replayed, len(remaining), writeErr)
+	}
+
+	d.logger.Infof("[DeadLetter] Replayed %d metrics", replayed)
+
+	return replayed, nil
+}
+
+func (d *DeadLetterFile) replayFilename() string {
+	return d.filename + ".replay"
+}
+
+// takeForReplay appends the dead letter file to the replay file, which may still contain the metrics of an
+// interrupted replay, truncates the dead letter file and returns the content of the replay file
+func (d *DeadLetterFile) takeForReplay() ([]byte, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	content, err := os.ReadFile(d.filename)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read dead letter file %s: %s", d.filename, err)
+	}
+
+	replay, err := os.OpenFile(d.replayFilename(), os.O_RDWR|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to open replay file %s: %s", d.replayFilename(), err)
+	}
+	defer replay.Close()
+
+	if _, err := replay.Write(content); err != nil {
+		return nil, fmt.Errorf("Failed to write replay file %s: %s", d.replayFilename(), err)
+	}
+
+	if err := replay.Sync(); err != nil {
+		return nil, fmt.Errorf("Failed to write replay file %s: %s", d.replayFilename(), err)
+	}
+
+	if err := d.file.Truncate(0); err != nil {
+		return nil, fmt.Errorf("Failed to truncate dead letter file %s: %s", d.filename, err)
+	}
+
+	content, err = os.ReadFile(d.replayFilename())
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read replay file %s: %s", d.replayFilename(), err)
+	}
+
+	return content, nil
+}
+
+func (d *DeadLetterFile) Close() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return d.file.Close()
+}
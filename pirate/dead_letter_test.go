@@ -0,0 +1,186 @@
+package pirate
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// recordingWriter keeps all written metrics and fails for the given metric names
+type recordingWriter struct {
+	metrics []*Metric
+	fail    map[string]bool
+}
+
+func (w *recordingWriter) Write(m *Metric) error {
+	if w.fail[string(m.Name)] {
+		return errors.New("write failed")
+	}
+
+	w.metrics = append(w.metrics, m)
+
+	return nil
+}
+
+func (w *recordingWriter) WriteRaw(path []byte, value []byte, timestamp []byte) error {
+	return w.Write(&Metric{Name: path, Value: value, Timestamp: timestamp})
+}
+
+func (w *recordingWriter) Close() error {
+	return nil
+}
+
+func newTestDeadLetterFile(t *testing.T) *DeadLetterFile {
+	d, err := NewDeadLetterFile(filepath.Join(t.TempDir(), "dead_letter.log"), newTestLogger(), NewMonitoringStats())
+	assert.Nil(t, err)
+	t.Cleanup(func() { d.Close() })
+
+	return d
+}
+
+func TestDeadLetterReplay(t *testing.T) {
+	d := newTestDeadLetterFile(t)
+
+	assert.Nil(t, d.Write(newInfluxTestMetric()))
+	assert.Nil(t, d.Write(NewMetric("pirate.metrics_received", 10, time.Unix(1234567890, 0))))
+
+	writer := &recordingWriter{}
+	replayed, err := d.Replay(writer.Write)
+
+	assert.Nil(t, err)
+	assert.Equal(t, 2, replayed)
+	assert.Len(t, writer.metrics, 2)
+
+	// replayed metrics are written to influx like the original ones
+	assert.Equal(t, string(influxLine(newInfluxTestMetric(), false)), string(influxLine(writer.metrics[0], false)))
+	assert.Equal(t, "pirate.metrics_received value=10 1234567890000000000\n", string(influxLine(writer.metrics[1], false)))
+
+	content, err := os.ReadFile(d.filename)
+	assert.Nil(t, err)
+	assert.Empty(t, content)
+
+	counters := d.stats.Reset()
+	assert.Equal(t, 2, counters["metrics_dead_lettered"])
+	assert.Equal(t, 2, counters["metrics_replayed"])
+}
+
+func TestDeadLetterReplayStopsAtFailure(t *testing.T) {
+	d := newTestDeadLetterFile(t)
+
+	failing := newInfluxTestMetric()
+	failing.Name = []byte("games.awesome_game.ios.errors")
+	following := NewMetric("pirate.metrics_received", 10, time.Unix(1234567890, 0))
+
+	assert.Nil(t, d.Write(newInfluxTestMetric()))
+	assert.Nil(t, d.Write(failing))
+	assert.Nil(t, d.Write(following))
+
+	writer := &recordingWriter{fail: map[string]bool{"games.awesome_game.ios.errors": true}}
+	replayed, err := d.Replay(writer.Write)
+
+	assert.NotNil(t, err)
+	assert.Equal(t, 1, replayed)
+	assert.Len(t, writer.metrics, 1)
+
+	// the failed metric and all following ones stay in the file
+	content, err := os.ReadFile(d.filename)
+	assert.Nil(t, err)
+	assert.Equal(t, string(spillLine(failing))+string(spillLine(following)), string(content))
+	assert.Equal(t, 1, d.stats.Reset()["metrics_replayed"])
+
+	_, err = os.Stat(d.replayFilename())
+	assert.True(t, os.IsNotExist(err), "Replay file must be removed")
+}
+
+func TestDeadLetterReplayGraphiteLines(t *testing.T) {
+	d := newTestDeadLetterFile(t)
+
+	// files of older versions only contain graphite lines
+	_, err := d.file.WriteString("games.awesome_game.ios.fps 55 1234567890\nmalformed\n")
+	assert.Nil(t, err)
+
+	writer := &recordingWriter{}
+	replayed, err := d.Replay(writer.Write)
+
+	assert.Nil(t, err)
+	assert.Equal(t, 1, replayed)
+	assert.Equal(t, "games.awesome_game.ios.fps 55 1234567890\n", string(GraphiteFormat(writer.metrics[0])))
+}
+
+func TestDeadLetterReplaySubSecondTimestamps(t *testing.T) {
+	d := newTestDeadLetterFile(t)
+
+	m := newInfluxTestMetric()
+	m.SetTime(time.Unix(1234567890, 250000000), time.Millisecond)
+	assert.Nil(t, d.Write(m))
+
+	writer := &recordingWriter{}
+	_, err := d.Replay(writer.Write)
+
+	assert.Nil(t, err)
+	assert.Equal(t, m.Nanos, writer.metrics[0].Nanos)
+	assert.Equal(t, m.Precision, writer.metrics[0].Precision)
+	assert.Equal(t, "fps,platform=ios,project=awesome_game value=55 1234567890250000000\n", string(influxLine(writer.metrics[0], false)))
+	assert.Equal(t, []byte("1234567890.250"), graphiteTimestamp(writer.metrics[0], TimestampNone))
+}
+
+// blockingWriter blocks all writes until chRelease got closed
+type blockingWriter struct {
+	recordingWriter
+	chWriting chan struct{}
+	chRelease chan struct{}
+}
+
+func (w *blockingWriter) Write(m *Metric) error {
+	close(w.chWriting)
+	<-w.chRelease
+
+	return w.recordingWriter.Write(m)
+}
+
+func TestDeadLetterWriteDuringReplay(t *testing.T) {
+	d := newTestDeadLetterFile(t)
+	assert.Nil(t, d.Write(newInfluxTestMetric()))
+
+	writer := &blockingWriter{chWriting: make(chan struct{}), chRelease: make(chan struct{})}
+	chReplayed := make(chan int)
+	go func() {
+		replayed, err := d.Replay(writer.Write)
+		assert.Nil(t, err)
+		chReplayed <- replayed
+	}()
+
+	// metrics can be dead-lettered while the replay writes to the target
+	<-writer.chWriting
+	metric := NewMetric("pirate.metrics_received", 10, time.Unix(1234567890, 0))
+	assert.Nil(t, d.Write(metric))
+
+	close(writer.chRelease)
+	assert.Equal(t, 1, <-chReplayed)
+
+	content, err := os.ReadFile(d.filename)
+	assert.Nil(t, err)
+	assert.Equal(t, string(spillLine(metric)), string(content))
+
+	_, err = os.Stat(d.replayFilename())
+	assert.True(t, os.IsNotExist(err), "Replay file must be removed")
+}
+
+func TestDeadLetterInterruptedReplay(t *testing.T) {
+	d := newTestDeadLetterFile(t)
+
+	// metrics of a replay, which did not finish
+	assert.Nil(t, os.WriteFile(d.replayFilename(), spillLine(NewMetric("a", 1, time.Unix(1234567890, 0))), 0644))
+	assert.Nil(t, d.Write(NewMetric("b", 2, time.Unix(1234567890, 0))))
+
+	writer := &recordingWriter{}
+	replayed, err := d.Replay(writer.Write)
+
+	assert.Nil(t, err)
+	assert.Equal(t, 2, replayed)
+	assert.Equal(t, []byte("a"), writer.metrics[0].Name)
+	assert.Equal(t, []byte("b"), writer.metrics[1].Name)
+}
@@ -112,3 +112,21 @@ type metricNameNode struct{}
 func (node metricNameNode) Resolve(ctx *Context) ([]byte, error) {
 	return ctx.metric.Name, nil
 }
+
+// AppendTags turns the given attributes into Graphite tags of the path (path;tag=value). Attributes,
+// which are missing in the context, are skipped.
+func AppendTags(path []byte, ctx *Context, tags []string) []byte {
+	for _, tag := range tags {
+		value, ok := ctx.attr[tag]
+		if !ok {
+			continue
+		}
+
+		path = append(path, ';')
+		path = append(path, tag...)
+		path = append(path, '=')
+		path = append(path, value...)
+	}
+
+	return path
+}
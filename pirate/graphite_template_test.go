@@ -198,3 +198,23 @@ func TestResolveNodes(t *testing.T) {
 		assert.Error(t, err)
 	})
 }
+
+func TestAppendTags(t *testing.T) {
+	ctx := &Context{attr: map[string][]byte{"platform": []byte("ios"), "version": []byte("1.3.37")}}
+
+	t.Run("no tags", func(t *testing.T) {
+		assert.Equal(t, []byte("foo.fps"), AppendTags([]byte("foo.fps"), ctx, nil))
+	})
+
+	t.Run("multiple tags", func(t *testing.T) {
+		res := AppendTags([]byte("foo.fps"), ctx, []string{"platform", "version"})
+
+		assert.Equal(t, []byte("foo.fps;platform=ios;version=1.3.37"), res, "dots must be kept in tag values")
+	})
+
+	t.Run("missing attribute", func(t *testing.T) {
+		res := AppendTags([]byte("foo.fps"), ctx, []string{"hostname", "platform"})
+
+		assert.Equal(t, []byte("foo.fps;platform=ios"), res)
+	})
+}
@@ -0,0 +1,226 @@
+package pirate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/op/go-logging"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	HttpResultTimeout = 5 * time.Second
+	// HttpReadTimeout stays below the shutdown deadline, so that no upload outlives Stop by more than HttpResultTimeout
+	HttpReadTimeout = 4 * time.Second
+)
+
+type HttpServer struct {
+	cfg           *HttpConfig
+	limits        *DecompressionConfig
+	server        *http.Server
+	logger        *logging.Logger
+	stats         *MonitoringStats
+	limiter       *IpLimiter
+	decompressors map[string]DecompressFunc
+	chPacket      chan<- *Packet
+	chStopped     chan struct{}
+	closing       bool
+	wg            sync.WaitGroup
+	mu            sync.Mutex
+}
+
+// httpEncodings maps the supported values of the Content-Encoding header to compressions, "deflate" means zlib in HTTP
+var httpEncodings = map[string]string{
+	"":         CompressionPlain,
+	"identity": CompressionPlain,
+	"gzip":     CompressionGzip,
+	"zstd":     CompressionZstd,
+	"deflate":  CompressionZlib,
+}
+
+// NewHttpServer creates a server, which accepts messages via POST requests. The body may be compressed (indicated by
+// the Content-Encoding header) and is forwarded to the parser without further decompression.
+func NewHttpServer(cfg *HttpConfig, limits *DecompressionConfig, limiter *IpLimiter, logger *logging.Logger, stats *MonitoringStats, chPacket chan<- *Packet) (*HttpServer, error) {
+	s := &HttpServer{
+		cfg:           cfg,
+		limits:        limits,
+		logger:        logger,
+		stats:         stats,
+		limiter:       limiter,
+		decompressors: make(map[string]DecompressFunc),
+		chPacket:      chPacket,
+		chStopped:     make(chan struct{}),
+	}
+
+	for _, compression := range httpEncodings {
+		if _, exists := s.decompressors[compression]; exists {
+			continue
+		}
+
+		decompress, err := NewDecompressor(compression)
+		if err != nil {
+			return nil, err
+		}
+		s.decompressors[compression] = decompress
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(cfg.Path, s.handleMessage)
+
+	s.server = &http.Server{
+		Addr:              cfg.Address,
+		Handler:           mux,
+		ReadHeaderTimeout: 2 * time.Second,
+		ReadTimeout:       HttpReadTimeout,
+	}
+
+	return s, nil
+}
+
+// Run serves requests until Stop was called and all pending requests are finished.
+func (s *HttpServer) Run() error {
+	s.logger.Infof("[HTTP] Listening on %s%s", s.cfg.Address, s.cfg.Path)
+
+	if err := s.server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return fmt.Errorf("Unable to start HTTP server on %s: %s", s.cfg.Address, err)
+	}
+
+	<-s.chStopped
+	s.wg.Wait()
+	s.logger.Info("[HTTP] Server stopped")
+
+	return nil
+}
+
+// Stop rejects new requests and shuts the server down. Requests which outlive the shutdown deadline are still finished
+// before Run returns, so that no handler sends to the packet channel after it got closed.
+func (s *HttpServer) Stop() {
+	s.mu.Lock()
+	s.closing = true
+	s.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), HttpResultTimeout)
+	defer cancel()
+
+	s.server.Shutdown(ctx)
+	close(s.chStopped)
+}
+
+func (s *HttpServer) track() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closing {
+		return false
+	}
+
+	s.wg.Add(1)
+
+	return true
+}
+
+func (s *HttpServer) handleMessage(w http.ResponseWriter, r *http.Request) {
+	if !s.track() {
+		http.Error(w, "Server is shutting down", http.StatusServiceUnavailable)
+		return
+	}
+	defer s.wg.Done()
+
+	if s.cfg.CorsOrigin != "" {
+		w.Header().Set("Access-Control-Allow-Origin", s.cfg.CorsOrigin)
+		w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Encoding")
+	}
+
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	now := time.Now()
+	ip := net.ParseIP(hostOf(r.RemoteAddr))
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, s.cfg.MaxBodySize))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to read body: %s", err), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	s.logger.Debugf("[HTTP] Received %d bytes", len(body))
+	s.stats.IncBytesIn(len(body))
+	s.stats.IncHttpReceived()
+
+	// check rate limit
+	if !s.limiter.Allow(ip) {
+		s.logger.Infof("[HTTP] Rate Limit reached for address: %s", ip)
+		s.stats.IncHttpDropped()
+		http.Error(w, "Rate limit reached", http.StatusTooManyRequests)
+
+		return
+	}
+
+	compression, ok := httpEncodings[r.Header.Get("Content-Encoding")]
+	if !ok {
+		http.Error(w, "Unsupported content encoding", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	if body, err = s.decompressors[compression](body, s.limits.Limit(len(body))); err != nil {
+		if errors.Is(err, ErrDecompressionLimit) {
+			s.stats.IncDecompressionLimitExceeded()
+			if s.limits.Penalty > 0 {
+				s.limiter.AllowN(ip, s.limits.Penalty)
+			}
+		}
+
+		http.Error(w, fmt.Sprintf("Rejected: %s", err), http.StatusBadRequest)
+		return
+	}
+
+	chResult := make(chan error, 1)
+	packet := NewPacket(body, ip, now)
+	packet.Compression = compression
+	packet.MessageFormat = s.cfg.MessageFormat
+	packet.NotifyResult(chResult)
+
+	select {
+	case s.chPacket <- packet:
+	default:
+		s.logger.Debug("[HTTP] Buffer is full, message got dropped")
+		s.stats.IncHttpDropped()
+		http.Error(w, "Buffer is full, try again later", http.StatusServiceUnavailable)
+
+		return
+	}
+
+	select {
+	case err := <-chResult:
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Rejected: %s", err), http.StatusBadRequest)
+			return
+		}
+	case <-time.After(HttpResultTimeout):
+		http.Error(w, "Timeout while processing message", http.StatusGatewayTimeout)
+		return
+	}
+
+	fmt.Fprintln(w, "Accepted")
+}
+
+func hostOf(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+
+	return host
+}
@@ -0,0 +1,182 @@
+package pirate
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestHttpServer(t *testing.T, limiter *IpLimiter, chPacket chan<- *Packet) *HttpServer {
+	cfg := *DefaultConfig.Http
+	cfg.MessageFormat = MessageFormatAuto
+
+	s, err := NewHttpServer(&cfg, DefaultConfig.Decompression, limiter, newTestLogger(), NewMonitoringStats(), chPacket)
+	assert.Nil(t, err)
+
+	return s
+}
+
+func postMessage(s *HttpServer, body string, encoding string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+	if encoding != "" {
+		r.Header.Set("Content-Encoding", encoding)
+	}
+
+	w := httptest.NewRecorder()
+	s.server.Handler.ServeHTTP(w, r)
+
+	return w
+}
+
+// answer reports the result to the next packet sent to the channel
+func answer(t *testing.T, chPacket <-chan *Packet, result error) <-chan *Packet {
+	chReceived := make(chan *Packet, 1)
+
+	go func() {
+		select {
+		case packet := <-chPacket:
+			packet.Done(result)
+			chReceived <- packet
+		case <-time.After(time.Second):
+			t.Error("Packet was not received")
+			close(chReceived)
+		}
+	}()
+
+	return chReceived
+}
+
+func TestHttpServer(t *testing.T) {
+	chPacket := make(chan *Packet, 1)
+	s := newTestHttpServer(t, NewIpLimiter(100, time.Minute), chPacket)
+
+	t.Run("accepted", func(t *testing.T) {
+		chReceived := answer(t, chPacket, nil)
+
+		w := postMessage(s, "project=p;\nfps 1 1234567890\n", "")
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "*", w.Header().Get("Access-Control-Allow-Origin"))
+
+		packet := <-chReceived
+		assert.Equal(t, "project=p;\nfps 1 1234567890\n", string(packet.Payload))
+		assert.Equal(t, CompressionPlain, packet.Compression)
+		assert.Equal(t, MessageFormatAuto, packet.MessageFormat)
+	})
+
+	t.Run("rejected", func(t *testing.T) {
+		answer(t, chPacket, errors.New("Invalid message"))
+
+		w := postMessage(s, "project=p;\n", "")
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Equal(t, "Rejected: Invalid message\n", w.Body.String())
+	})
+
+	t.Run("compressed", func(t *testing.T) {
+		chReceived := answer(t, chPacket, nil)
+
+		w := postMessage(s, string(compressForTest(t, CompressionGzip)), "gzip")
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, compressionTestMsg, (<-chReceived).Payload)
+	})
+
+	t.Run("unsupported encoding", func(t *testing.T) {
+		w := postMessage(s, "project=p;\n", "br")
+
+		assert.Equal(t, http.StatusUnsupportedMediaType, w.Code)
+	})
+
+	t.Run("invalid compression", func(t *testing.T) {
+		w := postMessage(s, "project=p;\n", "gzip")
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	t.Run("method", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		s.server.Handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+
+		assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+
+		w = httptest.NewRecorder()
+		s.server.Handler.ServeHTTP(w, httptest.NewRequest(http.MethodOptions, "/", nil))
+
+		assert.Equal(t, http.StatusNoContent, w.Code)
+	})
+}
+
+func TestHttpServerDrops(t *testing.T) {
+	t.Run("full buffer", func(t *testing.T) {
+		s := newTestHttpServer(t, NewIpLimiter(100, time.Minute), make(chan *Packet))
+
+		w := postMessage(s, "project=p;\n", "")
+
+		assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	})
+
+	t.Run("rate limit", func(t *testing.T) {
+		s := newTestHttpServer(t, NewIpLimiter(0, time.Minute), make(chan *Packet))
+
+		w := postMessage(s, "project=p;\n", "")
+
+		assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	})
+
+	t.Run("body size", func(t *testing.T) {
+		s := newTestHttpServer(t, NewIpLimiter(100, time.Minute), make(chan *Packet))
+		s.cfg.MaxBodySize = 10
+
+		w := postMessage(s, "project=p;\nfps 1 1234567890\n", "")
+
+		assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+	})
+}
+
+func TestHttpServerShutdown(t *testing.T) {
+	chPacket := make(chan *Packet, 1)
+	s := newTestHttpServer(t, NewIpLimiter(100, time.Minute), chPacket)
+	s.server.Addr = "127.0.0.1:0"
+
+	chRun := make(chan error)
+	go func() {
+		chRun <- s.Run()
+	}()
+
+	// the upload is in progress as soon as the handler reads the first part of the body
+	body, upload := io.Pipe()
+	chResponse := make(chan *httptest.ResponseRecorder)
+	go func() {
+		w := httptest.NewRecorder()
+		s.server.Handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/", body))
+		chResponse <- w
+	}()
+
+	_, err := upload.Write([]byte("project=p;\n"))
+	assert.Nil(t, err)
+
+	s.Stop()
+
+	assert.Equal(t, http.StatusServiceUnavailable, postMessage(s, "project=p;\n", "").Code, "New requests must be rejected")
+
+	select {
+	case <-chRun:
+		t.Fatal("Run must wait for the upload")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	chReceived := answer(t, chPacket, nil)
+	_, err = upload.Write([]byte("fps 1 1234567890\n"))
+	assert.Nil(t, err)
+	upload.Close()
+
+	assert.Equal(t, http.StatusOK, (<-chResponse).Code)
+	assert.Equal(t, "project=p;\nfps 1 1234567890\n", string((<-chReceived).Payload))
+	assert.Nil(t, <-chRun)
+}
@@ -0,0 +1,73 @@
+package pirate
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+const (
+	InfluxMeasurementName    = "name"
+	InfluxMeasurementProject = "project"
+)
+
+var (
+	influxEscaper            = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `)
+	influxMeasurementEscaper = strings.NewReplacer(",", `\,`, " ", `\ `)
+)
+
+// NewInfluxFormat creates a line format for the InfluxDB line protocol. The project and all header attributes
+// become tags. Depending on the mode, either the metric name is the measurement (with a "value" field) or the
+// project is the measurement (with the metric name as field).
+func NewInfluxFormat(mode string) (LineFormat, error) {
+	switch mode {
+	case "", InfluxMeasurementName:
+		return func(m *Metric) []byte { return influxLine(m, false) }, nil
+	case InfluxMeasurementProject:
+		return func(m *Metric) []byte { return influxLine(m, true) }, nil
+	default:
+		return nil, fmt.Errorf(`Unsupported influx measurement (must be "name" or "project"): %s`, mode)
+	}
+}
+
+func influxLine(m *Metric, projectMeasurement bool) []byte {
+	var b strings.Builder
+
+	measurement, field := string(m.Key), "value"
+	if len(m.Key) == 0 {
+		// monitoring metrics have no original name
+		measurement = string(m.Name)
+	} else if projectMeasurement {
+		measurement, field = m.Project, string(m.Key)
+	}
+
+	b.WriteString(influxMeasurementEscaper.Replace(measurement))
+
+	keys := make([]string, 0, len(m.Attributes))
+	for key := range m.Attributes {
+		if projectMeasurement && key == "project" {
+			continue
+		}
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		b.WriteByte(',')
+		b.WriteString(influxEscaper.Replace(key))
+		b.WriteByte('=')
+		b.WriteString(influxEscaper.Replace(string(m.Attributes[key])))
+	}
+
+	b.WriteByte(' ')
+	b.WriteString(influxEscaper.Replace(field))
+	b.WriteByte('=')
+	b.Write(m.Value)
+
+	// the line protocol expects nanoseconds by default
+	b.WriteByte(' ')
+	b.Write(m.Timestamp)
+	fmt.Fprintf(&b, "%09d\n", m.Nanos)
+
+	return []byte(b.String())
+}
@@ -0,0 +1,66 @@
+package pirate
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func newInfluxTestMetric() *Metric {
+	return &Metric{
+		Name:      []byte("games.awesome_game.ios.fps"),
+		Value:     []byte("55"),
+		Timestamp: []byte("1234567890"),
+		Project:   "awesome_game",
+		Key:       []byte("fps"),
+		Attributes: map[string][]byte{
+			"project":  []byte("awesome_game"),
+			"platform": []byte("ios"),
+		},
+	}
+}
+
+func TestInfluxLine(t *testing.T) {
+	m := newInfluxTestMetric()
+
+	assert.Equal(t, "fps,platform=ios,project=awesome_game value=55 1234567890000000000\n", string(influxLine(m, false)))
+	assert.Equal(t, "awesome_game,platform=ios fps=55 1234567890000000000\n", string(influxLine(m, true)))
+
+	t.Run("sub-second timestamp", func(t *testing.T) {
+		m := newInfluxTestMetric()
+		m.Nanos = 250000000
+
+		assert.Equal(t, "fps,platform=ios,project=awesome_game value=55 1234567890250000000\n", string(influxLine(m, false)))
+	})
+
+	t.Run("monitoring metric", func(t *testing.T) {
+		m := NewMetric("pirate.metrics_received", 10, time.Unix(1234567890, 0))
+
+		assert.Equal(t, "pirate.metrics_received value=10 1234567890000000000\n", string(influxLine(m, false)))
+	})
+}
+
+func TestInfluxEscaping(t *testing.T) {
+	m := &Metric{
+		Value:     []byte("1"),
+		Timestamp: []byte("1234567890"),
+		Project:   "my project",
+		Key:       []byte("a,b=c d"),
+		Attributes: map[string][]byte{
+			"ta g": []byte("x,y=z"),
+		},
+	}
+
+	assert.Equal(t, `a\,b=c\ d,ta\ g=x\,y\=z value=1 1234567890000000000`+"\n", string(influxLine(m, false)))
+	assert.Equal(t, `my\ project,ta\ g=x\,y\=z a\,b\=c\ d=1 1234567890000000000`+"\n", string(influxLine(m, true)))
+}
+
+func TestNewInfluxFormat(t *testing.T) {
+	format, err := NewInfluxFormat(InfluxMeasurementProject)
+
+	assert.Nil(t, err)
+	assert.Equal(t, "awesome_game,platform=ios fps=55 1234567890000000000\n", string(format(newInfluxTestMetric())))
+
+	_, err = NewInfluxFormat("field")
+	assert.NotNil(t, err)
+}
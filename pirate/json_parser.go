@@ -0,0 +1,192 @@
+package pirate
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+const (
+	MessageFormatAuto = "auto"
+	MessageFormatLine = "line"
+	MessageFormatJSON = "json"
+)
+
+type jsonMessage struct {
+	Header  json.RawMessage `json:"header"`
+	Metrics json.RawMessage `json:"metrics"`
+}
+
+type jsonMetric struct {
+	Name  json.RawMessage `json:"name"`
+	Value json.RawMessage `json:"value"`
+	Ts    json.RawMessage `json:"ts"`
+}
+
+// IsJSONMessage detects JSON messages by their opening brace, which is no valid start of a header line
+func IsJSONMessage(b []byte) bool {
+	b = bytes.TrimLeft(b, " \t\r\n")
+
+	return len(b) > 0 && b[0] == '{'
+}
+
+// DecodeMessageFormat decodes the message in the given format, "auto" detects the format by the content
+func DecodeMessageFormat(b []byte, format string, msg *Message, lenient func(header map[string][]byte) bool) ([]*ParseError, error) {
+	if format == MessageFormatJSON || format != MessageFormatLine && IsJSONMessage(b) {
+		return DecodeJSONMessageLenient(b, msg, lenient)
+	}
+
+	return DecodeMessageLenient(b, msg, lenient)
+}
+
+func DecodeJSONMessage(b []byte, msg *Message) error {
+	_, err := DecodeJSONMessageLenient(b, msg, nil)
+
+	return err
+}
+
+// DecodeJSONMessageLenient decodes a message like {"header":{"project":"p"},"metrics":[{"name":"fps","value":55,"ts":1234567890}]}
+// to the same message as DecodeMessageLenient. Names, values and timestamps may be JSON strings or numbers and must
+// consist of the same chars as in the line format. The timestamp is optional.
+func DecodeJSONMessageLenient(b []byte, msg *Message, lenient func(header map[string][]byte) bool) ([]*ParseError, error) {
+	if msg == nil {
+		return nil, errors.New("Message must not be nil")
+	}
+
+	msg.Header = make(map[string][]byte)
+	msg.Metrics = make([]*Metric, 0, 10)
+
+	if !IsJSONMessage(b) {
+		return nil, &ParseError{Path: "$", Err: InvalidType}
+	}
+
+	var root jsonMessage
+	if err := json.Unmarshal(b, &root); err != nil {
+		return nil, fmt.Errorf("Invalid JSON: %w", err)
+	}
+
+	if !isJSONNull(root.Header) {
+		var header map[string]json.RawMessage
+		if root.Header[0] != '{' || json.Unmarshal(root.Header, &header) != nil {
+			return nil, &ParseError{Path: "$.header", Err: InvalidType}
+		}
+
+		for key, raw := range header {
+			path := jsonPath("$.header", key)
+			if !isAll([]byte(key), headerKeyChars) {
+				return nil, &ParseError{Path: path, Err: InvalidKey}
+			}
+
+			value, ok := jsonScalar(raw)
+			if !ok || !isAll(value, headerValueChars) {
+				return nil, &ParseError{Path: path, Err: InvalidValue}
+			}
+
+			msg.Header[key] = value
+		}
+	}
+
+	if isJSONNull(root.Metrics) {
+		return nil, nil
+	}
+
+	var metrics []json.RawMessage
+	if root.Metrics[0] != '[' || json.Unmarshal(root.Metrics, &metrics) != nil {
+		return nil, &ParseError{Path: "$.metrics", Err: InvalidType}
+	}
+
+	skipMetrics := lenient != nil && lenient(msg.Header)
+
+	var skipped []*ParseError
+	for i, raw := range metrics {
+		metric, parseErr := decodeJSONMetric(raw, fmt.Sprintf("$.metrics[%d]", i))
+		if parseErr != nil {
+			if !skipMetrics {
+				return nil, parseErr
+			}
+
+			skipped = append(skipped, parseErr)
+			continue
+		}
+
+		msg.Metrics = append(msg.Metrics, metric)
+	}
+
+	return skipped, nil
+}
+
+func decodeJSONMetric(raw json.RawMessage, path string) (*Metric, *ParseError) {
+	var m jsonMetric
+	if raw[0] != '{' || json.Unmarshal(raw, &m) != nil {
+		return nil, &ParseError{Path: path, Err: InvalidType}
+	}
+
+	name, ok := jsonScalar(m.Name)
+	if !ok || !isAll(name, metricKeyChars) {
+		return nil, &ParseError{Path: path + ".name", Err: InvalidKey}
+	}
+
+	value, ok := jsonScalar(m.Value)
+	if !ok || !isMetricValue(value) {
+		return nil, &ParseError{Path: path + ".value", Err: InvalidValue}
+	}
+
+	// the timestamp is optional like in the line format
+	if isJSONNull(m.Ts) {
+		return &Metric{Name: name, Value: value}, nil
+	}
+
+	ts, ok := jsonScalar(m.Ts)
+	if !ok || !isTimestamp(ts) {
+		return nil, &ParseError{Path: path + ".ts", Err: InvalidValue}
+	}
+
+	return &Metric{Name: name, Value: value, Timestamp: ts}, nil
+}
+
+// isTimestamp checks for digits with an optional unit suffix like the parser of the line format
+func isTimestamp(ts []byte) bool {
+	p := NewParser(ts)
+	if _, ok := p.readAny(timestampChars); !ok {
+		return false
+	}
+	p.readAny(timestampUnits)
+
+	return len(p.buf) == 0
+}
+
+// jsonScalar returns the content of a JSON string or the literal of a JSON number
+func jsonScalar(raw json.RawMessage) ([]byte, bool) {
+	if len(raw) == 0 {
+		return nil, false
+	}
+
+	switch c := raw[0]; {
+	case c == '"':
+		var s string
+		if err := json.Unmarshal(raw, &s); err != nil {
+			return nil, false
+		}
+
+		return []byte(s), true
+	case c == '-' || c >= '0' && c <= '9':
+		return raw, true
+	}
+
+	return nil, false
+}
+
+func isJSONNull(raw json.RawMessage) bool {
+	return len(raw) == 0 || bytes.Equal(raw, []byte("null"))
+}
+
+// jsonPath appends the key to the path, keys which are no valid identifiers are quoted
+func jsonPath(path string, key string) string {
+	if isAll([]byte(key), metricKeyChars) {
+		return path + "." + key
+	}
+
+	return path + "[" + strconv.Quote(key) + "]"
+}
@@ -0,0 +1,100 @@
+package pirate
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestJSONMessageDecoding(t *testing.T) {
+	lineMsg := &Message{}
+	err := DecodeMessage([]byte("project=my_project; version=1.3.37;\nfps 30 1234567890\nmemory_usage 1.5e6 1234567891250ms\nerrors NaN\n"), lineMsg)
+	assert.Nil(t, err)
+
+	jsonMsg := &Message{}
+	err = DecodeJSONMessage([]byte(`{
+		"header": {"project": "my_project", "version": "1.3.37"},
+		"metrics": [
+			{"name": "fps", "value": 30, "ts": 1234567890},
+			{"name": "memory_usage", "value": 1.5e6, "ts": "1234567891250ms"},
+			{"name": "errors", "value": "NaN"}
+		]
+	}`), jsonMsg)
+
+	assert.Nil(t, err)
+	assert.Equal(t, lineMsg, jsonMsg)
+}
+
+func TestInvalidJSONMessages(t *testing.T) {
+	tests := []struct {
+		name     string
+		msg      string
+		expected string
+	}{
+		{"no object", `[]`, "Invalid type at $"},
+		{"header type", `{"header": ["project"]}`, "Invalid type at $.header"},
+		{"header key", `{"header": {"Project": "p"}}`, "Invalid key at $.header.Project"},
+		{"quoted header key", `{"header": {"my project": "p"}}`, `Invalid key at $.header["my project"]`},
+		{"header value", `{"header": {"project": "p q"}}`, "Invalid value at $.header.project"},
+		{"header value type", `{"header": {"project": true}}`, "Invalid value at $.header.project"},
+		{"metrics type", `{"metrics": {}}`, "Invalid type at $.metrics"},
+		{"metric type", `{"metrics": [{"name": "fps", "value": 1}, 1]}`, "Invalid type at $.metrics[1]"},
+		{"metric name", `{"metrics": [{"name": "fps.avg", "value": 1}]}`, "Invalid key at $.metrics[0].name"},
+		{"missing name", `{"metrics": [{"value": 1}]}`, "Invalid key at $.metrics[0].name"},
+		{"metric value", `{"metrics": [{"name": "fps", "value": "1 2"}]}`, "Invalid value at $.metrics[0].value"},
+		{"metric value grammar", `{"metrics": [{"name": "fps", "value": "1.2.3"}]}`, "Invalid value at $.metrics[0].value"},
+		{"timestamp", `{"metrics": [{"name": "fps", "value": 1, "ts": 1234567890.5}]}`, "Invalid value at $.metrics[0].ts"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := DecodeJSONMessage([]byte(test.msg), &Message{})
+
+			assert.EqualError(t, err, test.expected)
+		})
+	}
+
+	t.Run("syntax", func(t *testing.T) {
+		err := DecodeJSONMessage([]byte(`{"header": `), &Message{})
+
+		assert.ErrorContains(t, err, "Invalid JSON")
+	})
+}
+
+func TestLenientJSONMessageDecoding(t *testing.T) {
+	msg := &Message{}
+
+	skipped, err := DecodeJSONMessageLenient([]byte(`{"header": {"project": "my_project"}, "metrics": [
+		{"name": "fps", "value": 30, "ts": 1234567890},
+		{"name": "***", "value": 1, "ts": 1234567890},
+		"fps 30 1234567890"
+	]}`), msg, func(header map[string][]byte) bool {
+		return string(header["project"]) == "my_project"
+	})
+
+	assert.Nil(t, err)
+	assert.Len(t, msg.Metrics, 1)
+	assert.Equal(t, []*ParseError{{Path: "$.metrics[1].name", Err: InvalidKey}, {Path: "$.metrics[2]", Err: InvalidType}}, skipped)
+	assert.Equal(t, "invalid_type", skipped[1].Type())
+}
+
+func TestDecodeMessageFormat(t *testing.T) {
+	jsonMsg := []byte(` {"header": {"project": "my_project"}}`)
+	lineMsg := []byte("project=my_project;\n")
+
+	for _, format := range []string{"", MessageFormatAuto, MessageFormatJSON} {
+		msg := &Message{}
+		_, err := DecodeMessageFormat(jsonMsg, format, msg, nil)
+
+		assert.Nil(t, err, format)
+		assert.Equal(t, []byte("my_project"), msg.Header["project"], format)
+	}
+
+	_, err := DecodeMessageFormat(jsonMsg, MessageFormatLine, &Message{}, nil)
+	assert.NotNil(t, err)
+
+	_, err = DecodeMessageFormat(lineMsg, MessageFormatAuto, &Message{}, nil)
+	assert.Nil(t, err)
+
+	_, err = DecodeMessageFormat(lineMsg, MessageFormatJSON, &Message{}, nil)
+	assert.NotNil(t, err)
+}
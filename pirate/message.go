@@ -1,25 +1,97 @@
 package pirate
 
 import (
+	"net"
 	"strconv"
 	"time"
 )
 
+type Packet struct {
+	Payload    []byte
+	IP         net.IP
+	ReceivedAt time.Time
+
+	// Listener the packet was received on, nil for other protocols than UDP
+	Listener *UdpListenerConfig
+
+	// Compression the payload was decompressed from
+	Compression string
+
+	// ID of the zstd dictionary the payload was decompressed with, 0 for none
+	DictionaryID uint32
+
+	// MessageFormat of the payload as configured for the listener, empty or "auto" to detect it by the content
+	MessageFormat string
+
+	// LimitKey the peer is rate limited by, if it is not limited by its IP, e.g. the credentials of unix socket peers
+	LimitKey string
+
+	result chan<- error
+}
+
+func NewPacket(payload []byte, ip net.IP, receivedAt time.Time) *Packet {
+	return &Packet{Payload: payload, IP: ip, ReceivedAt: receivedAt, Compression: CompressionPlain}
+}
+
+// limitKey returns the key the peer of the packet is rate limited by, which is empty for unknown peers
+func (p *Packet) limitKey() string {
+	if p.LimitKey != "" {
+		return p.LimitKey
+	}
+
+	if p.IP != nil {
+		return p.IP.String()
+	}
+
+	return ""
+}
+
+// NotifyResult makes the pipeline report the outcome of the packet to the given channel, which must be buffered.
+func (p *Packet) NotifyResult(ch chan<- error) {
+	p.result = ch
+}
+
+// Done reports the outcome of the packet: nil if it was accepted by the validator or the reason why it was dropped.
+func (p *Packet) Done(err error) {
+	if p != nil && p.result != nil {
+		p.result <- err
+		p.result = nil
+	}
+}
+
 type Message struct {
 	Header  map[string][]byte
 	Metrics []*Metric
+
+	// Project is set by the validator, so that later stages use the same config the message was validated with
+	Project *ProjectConfig
+
+	// Packet the message was parsed from
+	Packet *Packet
 }
 
 type Metric struct {
 	Name      []byte
 	Value     []byte
 	Timestamp []byte
+
+	// Nanos is the fraction of the second of the Timestamp, Precision the unit the client sent the timestamp with.
+	// Both are zero for timestamps in seconds.
+	Nanos     int
+	Precision time.Duration
+
+	// Project is set by the metric resolver and used for routing, it is empty for monitoring metrics
+	Project string
+
+	// Key and Attributes keep the original metric name and header of a resolved metric for non-Graphite formats
+	Key        []byte
+	Attributes map[string][]byte
 }
 
 func NewMetric(name string, value float32, timestamp time.Time) *Metric {
 	return &Metric{
-		[]byte(name),
-		strconv.AppendFloat(nil, float64(value), 'g', -1, 32),
-		strconv.AppendInt(nil, timestamp.Unix(), 10),
+		Name:      []byte(name),
+		Value:     strconv.AppendFloat(nil, float64(value), 'g', -1, 32),
+		Timestamp: strconv.AppendInt(nil, timestamp.Unix(), 10),
 	}
 }
@@ -6,14 +6,13 @@ import (
 )
 
 type metricWorker struct {
-	cfg      *Config
 	logger   *logging.Logger
 	chMsg    <-chan *Message
 	chMetric chan<- *Metric
 }
 
-func NewMetricWorker(cfg *Config, logger *logging.Logger, chMsg <-chan *Message, chMetric chan<- *Metric) *metricWorker {
-	return &metricWorker{cfg, logger, chMsg, chMetric}
+func NewMetricWorker(logger *logging.Logger, chMsg <-chan *Message, chMetric chan<- *Metric) *metricWorker {
+	return &metricWorker{logger, chMsg, chMetric}
 }
 
 func (w *metricWorker) Run(concurrency int) {
@@ -33,19 +32,34 @@ func (w *metricWorker) run(wg *sync.WaitGroup) {
 	var metricCfg *MetricConfig
 
 	for msg := range w.chMsg {
-		projectCfg = w.cfg.Projects[string(msg.Header["project"])]
+		projectCfg = msg.Project
+		pid := string(msg.Header["project"])
 
 		for _, metric := range msg.Metrics {
 			metricCfg = projectCfg.Metrics[string(metric.Name)]
 
-			path, err := metricCfg.GraphiteTemplate.Resolve(NewCtx(msg.Header, metric))
+			ctx := NewCtx(msg.Header, metric)
+			path, err := metricCfg.GraphiteTemplate.Resolve(ctx)
 			if err != nil {
 				w.logger.Errorf("[MetricResolver] %s", err)
 				continue
 			}
 
+			if len(projectCfg.GraphiteTags) > 0 {
+				path = AppendTags(path, ctx, projectCfg.GraphiteTags)
+			}
+
 			w.logger.Debugf("[MetricResolver] Resolved path of %s.%s to %s", msg.Header["project"], metric.Name, path)
-			w.chMetric <- &Metric{path, metric.Value, metric.Timestamp}
+			w.chMetric <- &Metric{
+				Name:       path,
+				Value:      metric.Value,
+				Timestamp:  metric.Timestamp,
+				Nanos:      metric.Nanos,
+				Precision:  metric.Precision,
+				Project:    pid,
+				Key:        metric.Name,
+				Attributes: msg.Header,
+			}
 		}
 	}
 
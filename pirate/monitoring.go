@@ -1,6 +1,7 @@
 package pirate
 
 import (
+	"fmt"
 	"github.com/op/go-logging"
 	"sync"
 	"time"
@@ -31,6 +32,55 @@ func (s *MonitoringStats) IncUdpDropped() {
 	s.add("udp_dropped", 1)
 }
 
+// udpSocketCounters are the counter names of a UDP socket, which are built once instead of for every packet
+type udpSocketCounters struct {
+	received string
+	dropped  string
+}
+
+func newUdpSocketCounters(socket int) udpSocketCounters {
+	return udpSocketCounters{
+		received: fmt.Sprintf("udp_socket_%d_received", socket),
+		dropped:  fmt.Sprintf("udp_socket_%d_dropped", socket),
+	}
+}
+
+func (s *MonitoringStats) IncUdpSocketReceived(socket udpSocketCounters) {
+	s.add(socket.received, 1)
+}
+
+func (s *MonitoringStats) IncUdpSocketDropped(socket udpSocketCounters) {
+	s.add(socket.dropped, 1)
+}
+
+func (s *MonitoringStats) IncHttpReceived() {
+	s.add("http_received", 1)
+}
+
+func (s *MonitoringStats) IncHttpDropped() {
+	s.add("http_dropped", 1)
+}
+
+func (s *MonitoringStats) IncConnections(listener string) {
+	s.add(listener+"_connections", 1)
+}
+
+func (s *MonitoringStats) IncConnectionsRejected(listener string) {
+	s.add(listener+"_connections_rejected", 1)
+}
+
+func (s *MonitoringStats) IncListenerReceived(listener string) {
+	s.add(listener+"_received", 1)
+}
+
+func (s *MonitoringStats) IncListenerDropped(listener string) {
+	s.add(listener+"_dropped", 1)
+}
+
+func (s *MonitoringStats) IncDecompressionLimitExceeded() {
+	s.add("decompression_limit_exceeded", 1)
+}
+
 func (s *MonitoringStats) IncMsgReceived() {
 	s.add("messages_received", 1)
 }
@@ -39,6 +89,22 @@ func (s *MonitoringStats) IncMsgDropped() {
 	s.add("messages_dropped", 1)
 }
 
+func (s *MonitoringStats) IncMsgDroppedProjectNotAllowed() {
+	s.add("messages_dropped_project_not_allowed", 1)
+}
+
+func (s *MonitoringStats) IncMsgDroppedCompressionNotAllowed() {
+	s.add("messages_dropped_compression_not_allowed", 1)
+}
+
+func (s *MonitoringStats) IncMetricLinesSkipped(errType string) {
+	s.add("metric_lines_skipped_"+errType, 1)
+}
+
+func (s *MonitoringStats) IncMsgDroppedDictionaryNotAllowed() {
+	s.add("messages_dropped_dictionary_not_allowed", 1)
+}
+
 func (s *MonitoringStats) IncMetricsReceived(delta int) {
 	s.add("metrics_received", delta)
 }
@@ -51,6 +117,70 @@ func (s *MonitoringStats) IncMetricsWritten() {
 	s.add("metrics_written", 1)
 }
 
+func (s *MonitoringStats) IncMetricsRetried(delta int) {
+	s.add("metrics_retried", delta)
+}
+
+// IncMetricsWriteDropped counts metrics, which the writer dropped after all attempts failed, separately from the
+// metrics dropped by the validator
+func (s *MonitoringStats) IncMetricsWriteDropped(delta int) {
+	s.add("metrics_write_dropped", delta)
+}
+
+func (s *MonitoringStats) IncMetricsDeadLettered() {
+	s.add("metrics_dead_lettered", 1)
+}
+
+func (s *MonitoringStats) IncMetricsReplayed() {
+	s.add("metrics_replayed", 1)
+}
+
+func (s *MonitoringStats) IncMetricsSpilled() {
+	s.add("metrics_spilled", 1)
+}
+
+func (s *MonitoringStats) IncMetricsUnspilled() {
+	s.add("metrics_unspilled", 1)
+}
+
+func (s *MonitoringStats) IncMetricsSpillDropped(delta int) {
+	s.add("metrics_spill_dropped", delta)
+}
+
+func (s *MonitoringStats) IncOutputDropped(name string) {
+	s.add("output_"+name+"_dropped", 1)
+}
+
+func (s *MonitoringStats) IncMirrorDropped() {
+	s.add("mirror_dropped", 1)
+}
+
+func (s *MonitoringStats) IncConfigReloaded() {
+	s.add("config_reloaded", 1)
+}
+
+func (s *MonitoringStats) IncConfigReloadFailed() {
+	s.add("config_reload_failed", 1)
+}
+
+// ObserveClockSkew counts the messages of a project by the direction of their client's clock skew and sums up the
+// absolute skew in seconds, so that the average and the maximum per interval can be reported
+func (s *MonitoringStats) ObserveClockSkew(project string, skew time.Duration) {
+	prefix := "clock_skew_" + project + "_"
+
+	s.add(prefix+"messages", 1)
+	if skew < 0 {
+		s.add(prefix+"ahead", 1)
+		skew = -skew
+	} else if skew > 0 {
+		s.add(prefix+"behind", 1)
+	}
+
+	seconds := int(skew / time.Second)
+	s.add(prefix+"seconds_sum", seconds)
+	s.max(prefix+"seconds_max", seconds)
+}
+
 func (s *MonitoringStats) add(key string, delta int) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
@@ -58,6 +188,15 @@ func (s *MonitoringStats) add(key string, delta int) {
 	s.stats[key] = s.stats[key] + delta
 }
 
+func (s *MonitoringStats) max(key string, value int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if current, exists := s.stats[key]; !exists || value > current {
+		s.stats[key] = value
+	}
+}
+
 func (s *MonitoringStats) Reset() map[string]int {
 	s.mu.Lock()
 	defer s.mu.Unlock()
@@ -69,41 +208,108 @@ func (s *MonitoringStats) Reset() map[string]int {
 }
 
 type MonitoringWorker struct {
-	cfg      *Config
-	logger   *logging.Logger
-	chMetric chan<- *Metric
-	stats    *MonitoringStats
+	cfg       *SharedConfig
+	logger    *logging.Logger
+	chMetric  chan<- *Metric
+	stats     *MonitoringStats
+	chFlush   chan struct{}
+	chFlushed chan struct{}
+	chStop    chan struct{}
+	chDone    chan struct{}
 }
 
-func NewMonitoringWorker(cfg *Config, logger *logging.Logger, chMetric chan<- *Metric, stats *MonitoringStats) *MonitoringWorker {
-	return &MonitoringWorker{cfg, logger, chMetric, stats}
+func NewMonitoringWorker(cfg *SharedConfig, logger *logging.Logger, chMetric chan<- *Metric, stats *MonitoringStats) *MonitoringWorker {
+	return &MonitoringWorker{
+		cfg:       cfg,
+		logger:    logger,
+		chMetric:  chMetric,
+		stats:     stats,
+		chFlush:   make(chan struct{}),
+		chFlushed: make(chan struct{}),
+		chStop:    make(chan struct{}),
+		chDone:    make(chan struct{}),
+	}
 }
 
 func (w *MonitoringWorker) Run() {
 	w.logger.Info("[Monitoring] Starting monitoring worker")
 
+	ticker := time.NewTicker(1 * time.Minute)
+	defer ticker.Stop()
+
+	chTick, chFlush := ticker.C, w.chFlush
 	for {
-		time.Sleep(1 * time.Minute)
-
-		now := time.Now()
-
-		for key, value := range w.stats.Reset() {
-			w.logger.Infof("[Monitoring] %s = %d", key, value)
-
-			if w.cfg.MonitoringEnabled {
-				rawMetric := NewMetric(key, float32(value), now)
-				path, err := w.cfg.MonitoringTemplate.Resolve(NewMonitoringCtx(rawMetric))
-				if err != nil {
-					w.logger.Errorf("[Monitoring] Failed to resolve path: %s", err)
-					continue
-				}
-
-				select {
-				case w.chMetric <- NewMetric(string(path), float32(value), now):
-				default:
-					w.logger.Noticef("[Monitoring] Write buffer is full, failed to send monitoring metric %s", key)
-				}
+		select {
+		case <-chTick:
+			w.flush(false)
+		case <-chFlush:
+			// final snapshot must not get lost, so wait for the writer, nothing is sent afterwards
+			w.flush(true)
+			chTick, chFlush = nil, nil
+			close(w.chFlushed)
+		case <-w.chStop:
+			if chTick != nil {
+				w.flush(true)
+			} else {
+				w.logStats()
 			}
+			close(w.chDone)
+
+			return
+		}
+	}
+}
+
+// Flush sends the current stats a last time and returns as soon as all monitoring metrics were handed over to the
+// writer, so that the metric channel can be closed afterwards. Stats which are counted later, e.g. while the outputs
+// drain their buffers, are only logged by Stop.
+func (w *MonitoringWorker) Flush() {
+	close(w.chFlush)
+	<-w.chFlushed
+}
+
+// Stop logs the stats, which were counted since Flush, a last time. Without Flush, the stats are sent like by Flush, so
+// that the metric channel must still be open in this case.
+func (w *MonitoringWorker) Stop() {
+	close(w.chStop)
+	<-w.chDone
+}
+
+func (w *MonitoringWorker) logStats() map[string]int {
+	stats := w.stats.Reset()
+	for key, value := range stats {
+		w.logger.Infof("[Monitoring] %s = %d", key, value)
+	}
+
+	return stats
+}
+
+func (w *MonitoringWorker) flush(wait bool) {
+	now := time.Now()
+	cfg := w.cfg.Load()
+
+	for key, value := range w.logStats() {
+		if !cfg.MonitoringEnabled {
+			continue
+		}
+
+		rawMetric := NewMetric(key, float32(value), now)
+		path, err := cfg.MonitoringTemplate.Resolve(NewMonitoringCtx(rawMetric))
+		if err != nil {
+			w.logger.Errorf("[Monitoring] Failed to resolve path: %s", err)
+			continue
+		}
+
+		metric := NewMetric(string(path), float32(value), now)
+		if wait {
+			w.chMetric <- metric
+			continue
+		}
+
+		select {
+		case w.chMetric <- metric:
+		default:
+			w.logger.Noticef("[Monitoring] Write buffer is full, failed to send monitoring metric %s", key)
 		}
 	}
 }
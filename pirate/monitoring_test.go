@@ -0,0 +1,55 @@
+package pirate
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func newTestMonitoringWorker(t *testing.T, stats *MonitoringStats, chMetric chan<- *Metric) *MonitoringWorker {
+	cfg, err := loadTestConfig(t, `
+monitoring_path: pirate.{metric.name}
+projects:
+  p:
+    graphite_path: p.{metric.name}
+    metrics:
+      fps: {min: 0, max: 100}
+`)
+	assert.Nil(t, err)
+
+	w := NewMonitoringWorker(NewSharedConfig(cfg), newTestLogger(), chMetric, stats)
+	go w.Run()
+
+	return w
+}
+
+func TestMonitoringWorkerStop(t *testing.T) {
+	t.Run("without flush", func(t *testing.T) {
+		chMetric := make(chan *Metric, 1)
+		w := newTestMonitoringWorker(t, NewMonitoringStats(), chMetric)
+
+		w.stats.IncUdpReceived()
+		w.Stop()
+
+		metric := <-chMetric
+		assert.Equal(t, "pirate.udp_received", string(metric.Name))
+		assert.Equal(t, "1", string(metric.Value))
+	})
+
+	t.Run("after flush", func(t *testing.T) {
+		chMetric := make(chan *Metric, 1)
+		w := newTestMonitoringWorker(t, NewMonitoringStats(), chMetric)
+
+		w.stats.IncUdpReceived()
+		w.Flush()
+		close(chMetric)
+
+		assert.Equal(t, "pirate.udp_received", string((<-chMetric).Name))
+
+		// counted while the outputs drain, after the metric channel got closed
+		w.stats.IncMetricsWritten()
+		w.Stop()
+
+		assert.Empty(t, w.stats.Reset(), "Stats must be logged by Stop")
+		assert.Empty(t, chMetric)
+	})
+}
@@ -0,0 +1,223 @@
+package pirate
+
+import (
+	"fmt"
+	"github.com/op/go-logging"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	OutputBufferSize = 1000
+
+	// OutputDropLogInterval limits the warnings about dropped metrics of an output
+	OutputDropLogInterval = 10 * time.Second
+)
+
+type Output struct {
+	cfg        *OutputConfig
+	projects   map[string]bool
+	writer     MetricWriter
+	deadLetter *DeadLetterFile
+	spill      *spillWorker
+	worker     *writerWorker
+	logger     *logging.Logger
+	chIn       chan *Metric
+	chWrite    chan *Metric
+
+	// dropped metrics since the last warning, only used by the router
+	dropped       int
+	droppedLogged time.Time
+}
+
+func NewOutput(cfg *OutputConfig, retry *RetryConfig, spill *SpillConfig, logger *logging.Logger, stats *MonitoringStats) (*Output, error) {
+	o := &Output{cfg: cfg, logger: logger, chIn: make(chan *Metric, OutputBufferSize)}
+
+	if len(cfg.Projects) > 0 {
+		o.projects = make(map[string]bool, len(cfg.Projects))
+		for _, pid := range cfg.Projects {
+			o.projects[pid] = true
+		}
+	}
+
+	var err error
+	if o.writer, err = NewWriter(cfg.Target, logger, stats); err != nil {
+		return nil, fmt.Errorf("Output %s: %s", cfg.Name, err)
+	}
+
+	if cfg.DeadLetterFile != "" {
+		if o.deadLetter, err = NewDeadLetterFile(cfg.DeadLetterFile, logger, stats); err != nil {
+			return nil, fmt.Errorf("Output %s: %s", cfg.Name, err)
+		}
+	}
+
+	// with spilling enabled, the spill worker sits between the output buffer and the writer
+	o.chWrite = o.chIn
+	if spill.Enabled {
+		o.chWrite = make(chan *Metric, OutputBufferSize)
+	}
+
+	o.worker = NewWriterWorker(o.writer, retry, o.deadLetter, logger, stats, o.chWrite)
+
+	if spill.Enabled {
+		outputSpill := *spill
+		outputSpill.Directory = filepath.Join(spill.Directory, cfg.Name)
+
+		if o.spill, err = NewSpillWorker(&outputSpill, o.worker.breaker, logger, stats, o.chIn, o.chWrite); err != nil {
+			return nil, fmt.Errorf("Output %s: %s", cfg.Name, err)
+		}
+	}
+
+	return o, nil
+}
+
+func (o *Output) Name() string {
+	return o.cfg.Name
+}
+
+func (o *Output) Match(m *Metric) bool {
+	if o.projects != nil && !o.projects[m.Project] {
+		return false
+	}
+
+	if o.cfg.PathRegex != nil && !o.cfg.PathRegex.Match(m.Name) {
+		return false
+	}
+
+	return true
+}
+
+// Run writes all metrics of the output buffer until it got closed and drained.
+func (o *Output) Run() {
+	if o.spill != nil {
+		go func() {
+			o.spill.Run()
+			close(o.chWrite)
+		}()
+	}
+
+	o.worker.Run(1)
+
+	if err := o.writer.Close(); err != nil {
+		o.logger.Errorf("[Output] Failed to close writer of %s: %s", o.cfg.Name, err)
+	}
+
+	if o.deadLetter != nil {
+		o.deadLetter.Close()
+	}
+}
+
+// ReplayDeadLetter writes the dead letter file of the output with the retry policy and circuit breaker of its writer
+// worker, so that a replay is neither started nor continued while the target is down.
+func (o *Output) ReplayDeadLetter() (int, error) {
+	if o.deadLetter == nil {
+		return 0, nil
+	}
+
+	if !o.worker.breaker.ready() {
+		return 0, errTargetDown
+	}
+
+	return o.deadLetter.Replay(func(m *Metric) error {
+		return o.worker.attempt(1, func() error { return o.writer.Write(m) })
+	})
+}
+
+type Router struct {
+	outputs  []*Output
+	all      []*Output
+	projects map[string]*Output
+	logger   *logging.Logger
+	stats    *MonitoringStats
+	chIn     <-chan *Metric
+}
+
+// NewRouter creates a router, which sends metrics of projects with their own target only to the output of that
+// target and all other metrics to all matching outputs. The projects of the outputs are fixed until a restart, so
+// that reloaded project targets do not change the routing.
+func NewRouter(outputs []*Output, projectOutputs []*Output, logger *logging.Logger, stats *MonitoringStats, chIn <-chan *Metric) *Router {
+	projects := make(map[string]*Output)
+	for _, output := range projectOutputs {
+		for _, pid := range output.cfg.Projects {
+			projects[pid] = output
+		}
+	}
+
+	all := append(append([]*Output{}, outputs...), projectOutputs...)
+
+	return &Router{outputs, all, projects, logger, stats, chIn}
+}
+
+func (r *Router) Run() {
+	wg := &sync.WaitGroup{}
+
+	r.logger.Infof("[Router] Starting %d outputs", len(r.all))
+	for _, output := range r.all {
+		wg.Add(1)
+		go func(output *Output) {
+			output.Run()
+			wg.Done()
+		}(output)
+	}
+
+	for metric := range r.chIn {
+		r.route(metric)
+	}
+
+	for _, output := range r.all {
+		close(output.chIn)
+	}
+
+	wg.Wait()
+}
+
+func (r *Router) route(metric *Metric) {
+	if output, exists := r.projects[metric.Project]; exists && metric.Project != "" {
+		r.send(output, metric)
+		return
+	}
+
+	for _, output := range r.outputs {
+		if output.Match(metric) {
+			r.send(output, metric)
+		}
+	}
+}
+
+// send drops the metric, if the buffer of the output is full, so that a slow output does not stall the others. Outputs
+// with block_when_full wait for space in their buffer instead.
+func (r *Router) send(output *Output, metric *Metric) {
+	if output.cfg.BlockWhenFull {
+		output.chIn <- metric
+		return
+	}
+
+	select {
+	case output.chIn <- metric:
+	default:
+		r.stats.IncOutputDropped(output.Name())
+
+		output.dropped++
+		if now := time.Now(); now.Sub(output.droppedLogged) >= OutputDropLogInterval {
+			r.logger.Warningf("[Router] Buffer of output %s is full, %d metrics got dropped", output.Name(), output.dropped)
+			output.dropped = 0
+			output.droppedLogged = now
+		}
+	}
+}
+
+func (r *Router) ReplayDeadLetters() (string, error) {
+	result := ""
+
+	for _, output := range r.all {
+		replayed, err := output.ReplayDeadLetter()
+		if err != nil {
+			return result, fmt.Errorf("Output %s: %s", output.Name(), err)
+		}
+
+		result += fmt.Sprintf("Replayed %d metrics of output %s\n", replayed, output.Name())
+	}
+
+	return result, nil
+}
@@ -0,0 +1,312 @@
+package pirate
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func loadTestConfig(t *testing.T, content string) (*Config, error) {
+	filename := filepath.Join(t.TempDir(), "config.yml")
+	if err := os.WriteFile(filename, []byte(content), 0644); err != nil {
+		return nil, err
+	}
+
+	return LoadConfig(filename)
+}
+
+func newTestOutput(cfg *OutputConfig) *Output {
+	o := &Output{cfg: cfg, chIn: make(chan *Metric, 10)}
+
+	if len(cfg.Projects) > 0 {
+		o.projects = make(map[string]bool, len(cfg.Projects))
+		for _, pid := range cfg.Projects {
+			o.projects[pid] = true
+		}
+	}
+
+	return o
+}
+
+func routedNames(output *Output) []string {
+	var names []string
+	for len(output.chIn) > 0 {
+		names = append(names, string((<-output.chIn).Name))
+	}
+
+	return names
+}
+
+func TestOutputMatch(t *testing.T) {
+	output := newTestOutput(&OutputConfig{Name: "client", Projects: []string{"client"}, PathRegex: regexp.MustCompile(`\.fps$`)})
+
+	assert.True(t, output.Match(&Metric{Name: []byte("client.ios.fps"), Project: "client"}))
+	assert.False(t, output.Match(&Metric{Name: []byte("client.ios.memory"), Project: "client"}))
+	assert.False(t, output.Match(&Metric{Name: []byte("backend.fps"), Project: "backend"}))
+	assert.False(t, output.Match(&Metric{Name: []byte("pirate.fps")}), "Monitoring metrics have no project")
+
+	all := newTestOutput(&OutputConfig{Name: "all"})
+	assert.True(t, all.Match(&Metric{Name: []byte("pirate.metrics_received")}))
+}
+
+func TestOutputReplayDeadLetter(t *testing.T) {
+	d := newTestDeadLetterFile(t)
+	a := NewMetric("a", 1, time.Unix(1234567890, 0))
+	b := NewMetric("b", 2, time.Unix(1234567890, 0))
+	assert.Nil(t, d.Write(a))
+	assert.Nil(t, d.Write(b))
+
+	writer := &recordingWriter{fail: map[string]bool{"a": true, "b": true}}
+	retry := &RetryConfig{MaxAttempts: 2, InitialBackoff: time.Millisecond, MaxBackoff: time.Minute}
+	o := &Output{cfg: &OutputConfig{Name: "graphite"}, writer: writer, deadLetter: d}
+	o.worker = NewWriterWorker(writer, retry, d, newTestLogger(), d.stats, nil)
+
+	assertDeadLetters := func(expected string) {
+		content, err := os.ReadFile(d.filename)
+		assert.Nil(t, err)
+		assert.Equal(t, expected, string(content))
+	}
+
+	t.Run("target fails", func(t *testing.T) {
+		replayed, err := o.ReplayDeadLetter()
+
+		assert.NotNil(t, err)
+		assert.Equal(t, 0, replayed)
+		assertDeadLetters(string(spillLine(a)) + string(spillLine(b)))
+		assert.Equal(t, 1, d.stats.Reset()["metrics_retried"], "The replay must stop after the retries of the first metric")
+	})
+
+	t.Run("target is down", func(t *testing.T) {
+		replayed, err := o.ReplayDeadLetter()
+
+		assert.ErrorIs(t, err, errTargetDown)
+		assert.Equal(t, 0, replayed)
+		assertDeadLetters(string(spillLine(a)) + string(spillLine(b)))
+		assert.Empty(t, d.stats.Reset())
+	})
+
+	t.Run("target is up again", func(t *testing.T) {
+		writer.fail = nil
+		o.worker.breaker.probeAt = time.Now()
+
+		replayed, err := o.ReplayDeadLetter()
+
+		assert.Nil(t, err)
+		assert.Equal(t, 2, replayed)
+		assert.Len(t, writer.metrics, 2)
+		assertDeadLetters("")
+	})
+}
+
+func TestRouter(t *testing.T) {
+	client := newTestOutput(&OutputConfig{Name: "client", Projects: []string{"client"}})
+	all := newTestOutput(&OutputConfig{Name: "all"})
+	team := newTestOutput(&OutputConfig{Name: "project_team", Target: "tcp://team:3002", Projects: []string{"team", "team2"}})
+
+	r := NewRouter([]*Output{client, all}, []*Output{team}, newTestLogger(), NewMonitoringStats(), nil)
+
+	r.route(&Metric{Name: []byte("client.fps"), Project: "client"})
+	r.route(&Metric{Name: []byte("backend.fps"), Project: "backend"})
+	r.route(&Metric{Name: []byte("team.fps"), Project: "team"})
+	r.route(&Metric{Name: []byte("team2.fps"), Project: "team2"})
+	r.route(&Metric{Name: []byte("pirate.metrics_received")})
+
+	assert.Equal(t, []string{"client.fps"}, routedNames(client))
+	assert.Equal(t, []string{"client.fps", "backend.fps", "pirate.metrics_received"}, routedNames(all))
+	assert.Equal(t, []string{"team.fps", "team2.fps"}, routedNames(team))
+}
+
+func TestRouterKeepsProjectTargetsOnReload(t *testing.T) {
+	cfg, err := loadTestConfig(t, `
+graphite_target: tcp://127.0.0.1:3002
+projects:
+  team:
+    graphite_path: team.{metric.name}
+    graphite_target: tcp://team:3002
+    metrics:
+      fps: {min: 0, max: 100}
+  other:
+    graphite_path: other.{metric.name}
+    metrics:
+      fps: {min: 0, max: 100}
+`)
+	assert.Nil(t, err)
+
+	global := newTestOutput(cfg.Outputs[0])
+	team := newTestOutput(cfg.ProjectOutputs[0])
+	r := NewRouter([]*Output{global}, []*Output{team}, newTestLogger(), NewMonitoringStats(), nil)
+
+	// the reloaded config moves "team" to the global target and "other" to a new target, which both require a restart
+	reloaded, err := loadTestConfig(t, `
+graphite_target: tcp://127.0.0.1:3002
+projects:
+  team:
+    graphite_path: team.{metric.name}
+    metrics:
+      fps: {min: 0, max: 100}
+  other:
+    graphite_path: other.{metric.name}
+    graphite_target: tcp://other:3002
+    metrics:
+      fps: {min: 0, max: 100}
+`)
+	assert.Nil(t, err)
+
+	chMsg := make(chan *Message, 2)
+	chMetric := make(chan *Metric, 2)
+	for _, pid := range []string{"team", "other"} {
+		chMsg <- &Message{
+			Header:  map[string][]byte{"project": []byte(pid)},
+			Metrics: []*Metric{{Name: []byte("fps"), Value: []byte("1"), Timestamp: []byte("1234567890")}},
+			Project: reloaded.Projects[pid],
+		}
+	}
+	close(chMsg)
+
+	NewMetricWorker(newTestLogger(), chMsg, chMetric).Run(1)
+	close(chMetric)
+
+	for metric := range chMetric {
+		r.route(metric)
+	}
+
+	assert.Equal(t, []string{"team.fps"}, routedNames(team))
+	assert.Equal(t, []string{"other.fps"}, routedNames(global))
+	assert.Empty(t, r.stats.Reset())
+}
+
+func TestRouterFullBuffer(t *testing.T) {
+	t.Run("drop by default", func(t *testing.T) {
+		output := newTestOutput(&OutputConfig{Name: "all"})
+		output.chIn = make(chan *Metric, 1)
+		r := NewRouter([]*Output{output}, nil, newTestLogger(), NewMonitoringStats(), nil)
+
+		r.route(&Metric{Name: []byte("a")})
+		r.route(&Metric{Name: []byte("b")})
+		r.route(&Metric{Name: []byte("c")})
+
+		assert.Equal(t, []string{"a"}, routedNames(output))
+		assert.Equal(t, 2, r.stats.Reset()["output_all_dropped"])
+	})
+
+	t.Run("block when full", func(t *testing.T) {
+		output := newTestOutput(&OutputConfig{Name: "all", BlockWhenFull: true})
+		output.chIn = make(chan *Metric, 1)
+		r := NewRouter([]*Output{output}, nil, newTestLogger(), NewMonitoringStats(), nil)
+
+		r.route(&Metric{Name: []byte("a")})
+
+		chRouted := make(chan struct{})
+		go func() {
+			r.route(&Metric{Name: []byte("b")})
+			close(chRouted)
+		}()
+
+		select {
+		case <-chRouted:
+			t.Fatal("Router must wait for the full output")
+		case <-time.After(50 * time.Millisecond):
+		}
+
+		assert.Equal(t, "a", string((<-output.chIn).Name))
+		<-chRouted
+		assert.Equal(t, []string{"b"}, routedNames(output))
+		assert.Empty(t, r.stats.Reset())
+	})
+}
+
+func TestRouterSlowOutput(t *testing.T) {
+	// the slow output never reads its buffer, the other one waits for space to receive every metric
+	slow := newTestOutput(&OutputConfig{Name: "slow"})
+	fast := newTestOutput(&OutputConfig{Name: "fast", BlockWhenFull: true})
+	r := NewRouter([]*Output{slow, fast}, nil, newTestLogger(), NewMonitoringStats(), nil)
+
+	const metrics = 1000
+	chReceived := make(chan int)
+	go func() {
+		received := 0
+		for range fast.chIn {
+			received++
+		}
+		chReceived <- received
+	}()
+
+	chRouted := make(chan struct{})
+	go func() {
+		for i := 0; i < metrics; i++ {
+			r.route(&Metric{Name: []byte("fps")})
+		}
+		close(chRouted)
+	}()
+
+	select {
+	case <-chRouted:
+	case <-time.After(5 * time.Second):
+		t.Fatal("The slow output must not stall the router")
+	}
+	close(fast.chIn)
+
+	assert.Equal(t, metrics, <-chReceived)
+	assert.Len(t, slow.chIn, cap(slow.chIn))
+	assert.Equal(t, metrics-cap(slow.chIn), r.stats.Reset()["output_slow_dropped"])
+}
+
+func TestRouterDrain(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "metrics.log")
+	stats := NewMonitoringStats()
+	output, err := NewOutput(&OutputConfig{Name: "file", Target: "file://" + filename, BlockWhenFull: true}, testRetryConfig, &SpillConfig{}, newTestLogger(), stats)
+	assert.Nil(t, err)
+
+	// more metrics than the output buffer holds are queued, before the shutdown closes the metric channel
+	const metrics = 2 * OutputBufferSize
+	chMetric := make(chan *Metric, metrics+10)
+	for i := 0; i < metrics; i++ {
+		chMetric <- &Metric{Name: []byte("fps"), Value: []byte(strconv.Itoa(i)), Timestamp: []byte("1234567890")}
+	}
+
+	monitoring := newTestMonitoringWorker(t, stats, chMetric)
+	stats.IncUdpReceived()
+
+	// the shutdown order of the server
+	monitoring.Flush()
+	close(chMetric)
+	NewRouter([]*Output{output}, nil, newTestLogger(), stats, chMetric).Run()
+	monitoring.Stop()
+
+	content, err := os.ReadFile(filename)
+	assert.Nil(t, err)
+
+	lines := strings.Split(strings.TrimSuffix(string(content), "\n"), "\n")
+	assert.Len(t, lines, metrics+1)
+	for i := 0; i < metrics; i++ {
+		assert.Equal(t, fmt.Sprintf("fps %d 1234567890", i), lines[i])
+	}
+	assert.True(t, strings.HasPrefix(lines[metrics], "pirate.udp_received 1 "), "The final snapshot must be written")
+	assert.Empty(t, stats.Reset(), "The counters of the drain must be logged")
+}
+
+func TestOutputsWithGlobalDeadLetterFile(t *testing.T) {
+	_, err := loadTestConfig(t, `
+writer_retry:
+  dead_letter_file: /tmp/dead_letter.log
+outputs:
+  - name: client
+    target: tcp://127.0.0.1:3002
+`)
+
+	assert.ErrorContains(t, err, "writer_retry.dead_letter_file")
+
+	cfg, err := loadTestConfig(t, `
+writer_retry:
+  dead_letter_file: /tmp/dead_letter.log
+`)
+
+	assert.Nil(t, err)
+	assert.Equal(t, "/tmp/dead_letter.log", cfg.Outputs[0].DeadLetterFile)
+}
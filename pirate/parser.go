@@ -3,6 +3,7 @@ package pirate
 import (
 	"bytes"
 	"errors"
+	"fmt"
 )
 
 var (
@@ -12,6 +13,7 @@ var (
 	InvalidKey       = errors.New("Invalid key")
 	InvalidValue     = errors.New("Invalid value")
 	IncompletePair   = errors.New("Incomplete pair")
+	InvalidType      = errors.New("Invalid type")
 	EndOfMetrics     = errors.New("End of metrics block was reached")
 
 	// basic char sets
@@ -26,20 +28,66 @@ var (
 	headerValueChars = append(alphaNum, []byte("+/-_.")...)
 	metricKeyChars   = append(alphaNum, '_')
 	timestampChars   = num
-	metricValueChars = append(num, '.')
+	timestampUnits   = []byte("mnsu")
+	metricValueChars = append(num, []byte(".+-eENnAaIiFfTtYy")...) // signed floats with exponent, NaN and Inf(inity)
+
+	// special float values, which are matched case-insensitively
+	metricValueSpecials = [][]byte{[]byte("nan"), []byte("inf"), []byte("infinity")}
 )
 
 type Parser struct {
 	buf []byte
 }
 
+// ParseError is a malformed metric, which got skipped in lenient mode, located by its line or by its path in a JSON
+// message
+type ParseError struct {
+	Line int
+	Path string
+	Err  error
+}
+
+func (e *ParseError) Error() string {
+	if e.Path != "" {
+		return fmt.Sprintf("%s at %s", e.Err, e.Path)
+	}
+
+	return fmt.Sprintf("%s in line %d", e.Err, e.Line)
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+// Type returns the error in snake case, e.g. for monitoring
+func (e *ParseError) Type() string {
+	switch e.Err {
+	case InvalidKey:
+		return "invalid_key"
+	case InvalidValue:
+		return "invalid_value"
+	case InvalidType:
+		return "invalid_type"
+	}
+
+	return "unknown"
+}
+
 func NewParser(b []byte) *Parser {
 	return &Parser{b}
 }
 
 func DecodeMessage(b []byte, msg *Message) error {
+	_, err := DecodeMessageLenient(b, msg, nil)
+
+	return err
+}
+
+// DecodeMessageLenient decodes a message like DecodeMessage. If lenient returns true for the parsed header, malformed
+// metric lines are skipped and returned instead of failing the whole message.
+func DecodeMessageLenient(b []byte, msg *Message, lenient func(header map[string][]byte) bool) ([]*ParseError, error) {
 	if msg == nil {
-		return errors.New("Message must not be nil")
+		return nil, errors.New("Message must not be nil")
 	}
 
 	msg.Header = make(map[string][]byte)
@@ -53,12 +101,17 @@ func DecodeMessage(b []byte, msg *Message) error {
 		}
 
 		if err != nil {
-			return err
+			return nil, err
 		}
 
 		msg.Header[string(key)] = value
 	}
 
+	skipLines := lenient != nil && lenient(msg.Header)
+
+	// the line number of skipped lines is counted incrementally from the last skipped one
+	var skipped []*ParseError
+	line, counted := 1, 0
 	for {
 		key, ts, value, err := p.ReadMetric()
 		if err == EndOfMetrics {
@@ -66,13 +119,24 @@ func DecodeMessage(b []byte, msg *Message) error {
 		}
 
 		if err != nil {
-			return err
+			if !skipLines {
+				return nil, err
+			}
+
+			pos := len(b) - len(p.buf)
+			line += bytes.Count(b[counted:pos], []byte{'\n'})
+			counted = pos
+
+			skipped = append(skipped, &ParseError{Line: line, Err: err})
+			p.SkipLine()
+
+			continue
 		}
 
-		msg.Metrics = append(msg.Metrics, &Metric{key, value, ts})
+		msg.Metrics = append(msg.Metrics, &Metric{Name: key, Value: value, Timestamp: ts})
 	}
 
-	return nil
+	return skipped, nil
 }
 
 func (p *Parser) ReadHeader() (key []byte, value []byte, err error) {
@@ -130,23 +194,42 @@ func (p *Parser) ReadMetric() (key []byte, ts []byte, value []byte, err error) {
 	p.skipSpaces()
 
 	// read value
-	if value, ok = p.readAny(metricValueChars); !ok {
+	if value, ok = p.readAny(metricValueChars); !ok || !isMetricValue(value) {
 		return nil, nil, nil, InvalidValue
 	}
 
 	p.skipSpaces()
 
+	// the timestamp is optional, the validator decides whether to fill in the receive time
+	if len(p.buf) == 0 || p.skipByte('\n') {
+		return key, nil, value, nil
+	}
+
 	// read timestamp
 	if ts, ok = p.readAny(timestampChars); !ok {
 		return nil, nil, nil, InvalidValue
 	}
 
+	// extend the timestamp by its optional unit suffix, which directly follows in the buffer
+	if unit, ok := p.readAny(timestampUnits); ok {
+		ts = ts[:len(ts)+len(unit)]
+	}
+
 	p.skipSpaces()
 	p.skipByte('\n')
 
 	return
 }
 
+// SkipLine skips the rest of the current line including its line break
+func (p *Parser) SkipLine() {
+	if i := bytes.IndexByte(p.buf, '\n'); i >= 0 {
+		p.buf = p.buf[i+1:]
+	} else {
+		p.buf = p.buf[len(p.buf):]
+	}
+}
+
 func (p *Parser) skipSpaces() {
 	var i int
 	for i = 0; i < len(p.buf); i++ {
@@ -186,6 +269,71 @@ func (p *Parser) readAny(allowed []byte) ([]byte, bool) {
 	return b, i > 0
 }
 
+// isMetricValue checks, if b is a float of the form [+-]digits[.digits][(e|E)[+-]digits] with at least one digit before
+// or after the dot, or NaN, Inf or Infinity in any case with an optional sign
+func isMetricValue(b []byte) bool {
+	if len(b) > 0 && (b[0] == '+' || b[0] == '-') {
+		b = b[1:]
+	}
+
+	for _, special := range metricValueSpecials {
+		if bytes.EqualFold(b, special) {
+			return true
+		}
+	}
+
+	digits := countDigits(b)
+	b = b[digits:]
+
+	if len(b) > 0 && b[0] == '.' {
+		fraction := countDigits(b[1:])
+		digits += fraction
+		b = b[1+fraction:]
+	}
+
+	if digits == 0 {
+		return false
+	}
+
+	if len(b) > 0 && (b[0] == 'e' || b[0] == 'E') {
+		b = b[1:]
+		if len(b) > 0 && (b[0] == '+' || b[0] == '-') {
+			b = b[1:]
+		}
+
+		exponent := countDigits(b)
+		if exponent == 0 {
+			return false
+		}
+		b = b[exponent:]
+	}
+
+	return len(b) == 0
+}
+
+// countDigits returns the number of leading digits of b
+func countDigits(b []byte) int {
+	var i int
+	for i = 0; i < len(b); i++ {
+		if b[i] < '0' || b[i] > '9' {
+			break
+		}
+	}
+
+	return i
+}
+
+// isAll checks, if b is not empty and consists of the given chars only
+func isAll(b []byte, chars []byte) bool {
+	for _, c := range b {
+		if !isAny(c, chars) {
+			return false
+		}
+	}
+
+	return len(b) > 0
+}
+
 func isAny(b byte, chars []byte) bool {
 	for _, c := range chars {
 		if b == c {
@@ -248,7 +248,7 @@ func TestSingleMetric(t *testing.T) {
 }
 
 func TestMultipleMetrics(t *testing.T) {
-	p := NewParser([]byte("   foo    20.6    1234567890     \n bar  17.999   12345\nbaz ... 1337"))
+	p := NewParser([]byte("   foo    20.6    1234567890     \n bar  17.999   12345\nbaz -1.5e3 1337"))
 
 	// first metric
 	key, ts, value, err := p.ReadMetric()
@@ -258,7 +258,7 @@ func TestMultipleMetrics(t *testing.T) {
 	assert.Equal(t, []byte("1234567890"), ts)
 	assert.Nil(t, err)
 
-	assert.Equal(t, []byte(" bar  17.999   12345\nbaz ... 1337"), p.buf)
+	assert.Equal(t, []byte(" bar  17.999   12345\nbaz -1.5e3 1337"), p.buf)
 
 	// second metric
 	key, ts, value, err = p.ReadMetric()
@@ -268,19 +268,80 @@ func TestMultipleMetrics(t *testing.T) {
 	assert.Equal(t, []byte("12345"), ts)
 	assert.Nil(t, err)
 
-	assert.Equal(t, []byte("baz ... 1337"), p.buf)
+	assert.Equal(t, []byte("baz -1.5e3 1337"), p.buf)
 
 	// third metric
 	key, ts, value, err = p.ReadMetric()
 
 	assert.Equal(t, []byte("baz"), key)
-	assert.Equal(t, []byte("..."), value) // this is absolutely valid here, actual validation must be done outside of the parser
+	assert.Equal(t, []byte("-1.5e3"), value)
 	assert.Equal(t, []byte("1337"), ts)
 	assert.Nil(t, err)
 
 	assert.Equal(t, []byte{}, p.buf)
 }
 
+func TestMetricValues(t *testing.T) {
+	for _, value := range []string{"-20.6", "+20.6", "1.2e-5", "-3E+10", ".5", "5.", "007", "nan", "NaN", "-Inf", "+Infinity", "INFINITY"} {
+		t.Run(value, func(t *testing.T) {
+			p := NewParser([]byte("foo " + value + " 1234567890\n"))
+
+			key, ts, v, err := p.ReadMetric()
+
+			assert.Equal(t, []byte("foo"), key)
+			assert.Equal(t, []byte(value), v)
+			assert.Equal(t, []byte("1234567890"), ts)
+			assert.Nil(t, err)
+		})
+	}
+
+	for _, value := range []string{"--e", "1.2.3", "aNtIfY", "+-+", "-", ".", "...", "e5", "1e", "1e+", "1.5e5.5", "nana", "infinit", "0x10"} {
+		t.Run(value, func(t *testing.T) {
+			p := NewParser([]byte("foo " + value + " 1234567890\n"))
+
+			_, _, _, err := p.ReadMetric()
+
+			assert.Equal(t, InvalidValue, err)
+		})
+	}
+}
+
+func TestTimestampUnits(t *testing.T) {
+	for _, ts := range []string{"1234567890123ms", "1234567890123456us", "1234567890s"} {
+		t.Run(ts, func(t *testing.T) {
+			p := NewParser([]byte("foo 20.6 " + ts + "\nbar 1 1234567890"))
+
+			key, v, value, err := p.ReadMetric()
+
+			assert.Equal(t, []byte("foo"), key)
+			assert.Equal(t, []byte("20.6"), value)
+			assert.Equal(t, []byte(ts), v)
+			assert.Nil(t, err)
+			assert.Equal(t, []byte("bar 1 1234567890"), p.buf)
+		})
+	}
+}
+
+func TestMissingTimestamp(t *testing.T) {
+	p := NewParser([]byte("foo 20.6  \nbar 17.999"))
+
+	key, ts, value, err := p.ReadMetric()
+
+	assert.Equal(t, []byte("foo"), key)
+	assert.Equal(t, []byte("20.6"), value)
+	assert.Nil(t, ts)
+	assert.Nil(t, err)
+
+	key, ts, value, err = p.ReadMetric()
+
+	assert.Equal(t, []byte("bar"), key)
+	assert.Equal(t, []byte("17.999"), value)
+	assert.Nil(t, ts)
+	assert.Nil(t, err)
+
+	assert.Equal(t, []byte{}, p.buf)
+}
+
 func TestInvalidMetrics(t *testing.T) {
 	t.Run("invalid key", func(t *testing.T) {
 		p := NewParser([]byte("  *** 123 123"))
@@ -353,9 +414,78 @@ func TestMessageDecoding(t *testing.T) {
 		assert.Len(t, msg.Metrics, 2)
 		assert.Equal(t, []byte("my_project"), msg.Header["project"])
 		assert.Equal(t, []byte("bar"), msg.Header["foo"])
-		assert.Equal(t, &Metric{[]byte("fps"), []byte("30"), []byte("1234567890")}, msg.Metrics[0])
-		assert.Equal(t, &Metric{[]byte("memory_usage"), []byte("102400"), []byte("1234567891")}, msg.Metrics[1])
+		assert.Equal(t, &Metric{Name: []byte("fps"), Value: []byte("30"), Timestamp: []byte("1234567890")}, msg.Metrics[0])
+		assert.Equal(t, &Metric{Name: []byte("memory_usage"), Value: []byte("102400"), Timestamp: []byte("1234567891")}, msg.Metrics[1])
+		assert.Nil(t, err)
+	})
+}
+
+func TestLenientMessageDecoding(t *testing.T) {
+	rawMsg := []byte("project=my_project;\nfps 30 1234567890\n*** 1 1234567890\nfps invalid 1234567890\nmemory_usage 102400 1234567891")
+
+	t.Run("lenient", func(t *testing.T) {
+		msg := &Message{}
+
+		skipped, err := DecodeMessageLenient(rawMsg, msg, func(header map[string][]byte) bool {
+			return string(header["project"]) == "my_project"
+		})
+
+		assert.Nil(t, err)
+		assert.Len(t, msg.Metrics, 2)
+		assert.Equal(t, []byte("fps"), msg.Metrics[0].Name)
+		assert.Equal(t, []byte("memory_usage"), msg.Metrics[1].Name)
+		assert.Equal(t, []*ParseError{{Line: 3, Err: InvalidKey}, {Line: 4, Err: InvalidValue}}, skipped)
+		assert.Equal(t, "invalid_key", skipped[0].Type())
+		assert.Equal(t, "Invalid value in line 4", skipped[1].Error())
+	})
+
+	t.Run("strict", func(t *testing.T) {
+		msg := &Message{}
+
+		skipped, err := DecodeMessageLenient(rawMsg, msg, func(header map[string][]byte) bool {
+			return false
+		})
+
+		assert.Nil(t, skipped)
+		assert.Equal(t, InvalidKey, err)
+	})
+
+	t.Run("last line", func(t *testing.T) {
+		msg := &Message{}
+
+		skipped, err := DecodeMessageLenient([]byte("project=my_project;\nfps 30 1234567890\nfps 30 abc"), msg, func(header map[string][]byte) bool {
+			return true
+		})
+
+		assert.Nil(t, err)
+		assert.Len(t, msg.Metrics, 1)
+		assert.Equal(t, []*ParseError{{Line: 3, Err: InvalidValue}}, skipped)
+	})
+
+	t.Run("line numbers", func(t *testing.T) {
+		var b bytes.Buffer
+		b.WriteString("project=my_project;\n")
+
+		var expected []*ParseError
+		for i := 2; i <= 1000; i++ {
+			switch i % 3 {
+			case 0:
+				b.WriteString("fps 30 1234567890\n")
+			case 1:
+				b.WriteString("fps 3.0.0 1234567890\n")
+				expected = append(expected, &ParseError{Line: i, Err: InvalidValue})
+			case 2:
+				b.WriteString("*** 30 1234567890\n")
+				expected = append(expected, &ParseError{Line: i, Err: InvalidKey})
+			}
+		}
+
+		skipped, err := DecodeMessageLenient(b.Bytes(), &Message{}, func(header map[string][]byte) bool {
+			return true
+		})
+
 		assert.Nil(t, err)
+		assert.Equal(t, expected, skipped)
 	})
 }
 
@@ -6,13 +6,15 @@ import (
 )
 
 type ParserWorker struct {
+	cfg    *SharedConfig
 	logger *logging.Logger
-	chUdp  <-chan []byte
+	stats  *MonitoringStats
+	chUdp  <-chan *Packet
 	chMsg  chan<- *Message
 }
 
-func NewParserWorker(logger *logging.Logger, chUdp <-chan []byte, chMsg chan<- *Message) *ParserWorker {
-	return &ParserWorker{logger, chUdp, chMsg}
+func NewParserWorker(cfg *SharedConfig, logger *logging.Logger, stats *MonitoringStats, chUdp <-chan *Packet, chMsg chan<- *Message) *ParserWorker {
+	return &ParserWorker{cfg, logger, stats, chUdp, chMsg}
 }
 
 func (w *ParserWorker) Run(concurrency int) {
@@ -28,17 +30,34 @@ func (w *ParserWorker) Run(concurrency int) {
 }
 
 func (w *ParserWorker) run(wg *sync.WaitGroup) {
-	for udp := range w.chUdp {
-		msg := &Message{}
+	lenient := w.isLenient
 
-		if err := DecodeMessage(udp, msg); err != nil {
+	for packet := range w.chUdp {
+		msg := &Message{Packet: packet}
+
+		skipped, err := DecodeMessageFormat(packet.Payload, packet.MessageFormat, msg, lenient)
+		if err != nil {
 			w.logger.Warningf("[Parser] Error: %s", err)
+			packet.Done(err)
 			continue
 		}
 
-		w.logger.Debugf("[Parser] Parsed %d bytes to %d headers and %d metrics", len(udp), len(msg.Header), len(msg.Metrics))
+		// skipped lines are counted, but only logged at debug level, as a broken client may send lots of them
+		for _, parseErr := range skipped {
+			w.logger.Debugf("[Parser] Skipped metric of project %s: %s", msg.Header["project"], parseErr)
+			w.stats.IncMetricLinesSkipped(parseErr.Type())
+		}
+
+		w.logger.Debugf("[Parser] Parsed %d bytes to %d headers and %d metrics", len(packet.Payload), len(msg.Header), len(msg.Metrics))
 		w.chMsg <- msg
 	}
 
 	wg.Done()
 }
+
+// isLenient checks, if the project of the message skips malformed metric lines
+func (w *ParserWorker) isLenient(header map[string][]byte) bool {
+	project, exists := w.cfg.Load().Projects[string(header["project"])]
+
+	return exists && project.LenientParsing
+}
@@ -0,0 +1,207 @@
+package pirate
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"github.com/op/go-logging"
+	"math"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	PickleDefaultBatchSize     = 500
+	PickleDefaultFlushInterval = 1 * time.Second
+)
+
+// pickleWriter sends batches of metrics as carbon's length-prefixed pickle frames, which contain a list of
+// (path, (timestamp, value)) tuples. The batches are collected by the writer worker, so that retries and the dead
+// letter file apply to batched metrics as well.
+type pickleWriter struct {
+	addr          string
+	conn          net.Conn
+	batchSize     int
+	flushInterval time.Duration
+	rounding      string
+	logger        *logging.Logger
+	stats         *MonitoringStats
+	mu            sync.Mutex
+}
+
+func NewPickleWriter(addr string, batchSize int, flushInterval time.Duration, rounding string, logger *logging.Logger, stats *MonitoringStats) (*pickleWriter, error) {
+	conn, err := net.DialTimeout("tcp", addr, TcpDialTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("TCP error: %s", err)
+	}
+
+	return &pickleWriter{
+		addr:          addr,
+		conn:          conn,
+		batchSize:     batchSize,
+		flushInterval: flushInterval,
+		rounding:      rounding,
+		logger:        logger,
+		stats:         stats,
+	}, nil
+}
+
+func (w *pickleWriter) Write(m *Metric) error {
+	return w.WriteBatch([]*Metric{m})
+}
+
+func (w *pickleWriter) WriteRaw(path []byte, value []byte, timestamp []byte) error {
+	return w.Write(&Metric{Name: path, Value: value, Timestamp: timestamp})
+}
+
+// WriteBatch sends all metrics in a single frame. Metrics, which can not be encoded, are dropped without failing the
+// rest of the batch.
+func (w *pickleWriter) WriteBatch(metrics []*Metric) error {
+	payload, encoded, errs := encodePickle(metrics, w.rounding)
+	for _, err := range errs {
+		w.logger.Errorf("[Pickle Writer] Dropping metric: %s", err)
+	}
+	w.stats.IncMetricsDropped(len(errs))
+
+	if encoded == 0 {
+		return nil
+	}
+
+	frame := make([]byte, 4, 4+len(payload))
+	binary.BigEndian.PutUint32(frame, uint32(len(payload)))
+	frame = append(frame, payload...)
+
+	w.logger.Debugf("[Pickle Writer] Writing %d metrics in %d bytes", encoded, len(frame))
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if err := w.write(frame); err != nil {
+		return err
+	}
+
+	for i := 0; i < encoded; i++ {
+		w.stats.IncMetricsWritten()
+	}
+	w.stats.IncBytesOut(len(frame))
+
+	return nil
+}
+
+func (w *pickleWriter) BatchSize() int {
+	return w.batchSize
+}
+
+func (w *pickleWriter) FlushInterval() time.Duration {
+	return w.flushInterval
+}
+
+// Close closes the connection, it may be called more than once
+func (w *pickleWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.conn == nil {
+		return nil
+	}
+
+	err := w.conn.Close()
+	w.conn = nil
+
+	return err
+}
+
+func (w *pickleWriter) write(frame []byte) error {
+	if w.conn != nil {
+		if _, err := w.conn.Write(frame); err == nil {
+			return nil
+		}
+
+		w.logger.Warningf("[Pickle Writer] Failed to write batch, trying to reconnect")
+		w.conn.Close()
+		w.conn = nil
+	}
+
+	// a single attempt only, retries and backoff are up to the writer worker
+	conn, err := net.DialTimeout("tcp", w.addr, TcpDialTimeout)
+	if err != nil {
+		return fmt.Errorf("[Pickle Writer] Failed to reconnect: %s", err)
+	}
+
+	w.conn = conn
+	w.logger.Info("[Pickle Writer] Reconnected successfully")
+
+	if _, err := w.conn.Write(frame); err != nil {
+		return fmt.Errorf("[Pickle Writer] Failed to write batch: %s", err)
+	}
+
+	return nil
+}
+
+const (
+	pickleProto      = 0x80
+	pickleEmptyList  = ']'
+	pickleMark       = '('
+	pickleAppends    = 'e'
+	pickleBinUnicode = 'X'
+	pickleBinInt     = 'J'
+	pickleLong1      = 0x8a
+	pickleBinFloat   = 'G'
+	pickleTuple2     = 0x86
+	pickleStop       = '.'
+)
+
+// encodePickle encodes all valid metrics and returns their number and the errors of the invalid ones
+func encodePickle(metrics []*Metric, rounding string) ([]byte, int, []error) {
+	buf := make([]byte, 0, len(metrics)*64)
+	buf = append(buf, pickleProto, 2, pickleEmptyList, pickleMark)
+
+	encoded := 0
+	var errs []error
+	for _, m := range metrics {
+		timestamp := graphiteTimestamp(m, rounding)
+		fractional := bytes.IndexByte(timestamp, '.') >= 0
+
+		ts, err := strconv.ParseFloat(string(timestamp), 64)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("invalid timestamp %q of %s", timestamp, m.Name))
+			continue
+		}
+
+		value, err := strconv.ParseFloat(string(m.Value), 64)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("invalid value %q of %s", m.Value, m.Name))
+			continue
+		}
+
+		// path
+		buf = append(buf, pickleBinUnicode)
+		buf = binary.LittleEndian.AppendUint32(buf, uint32(len(m.Name)))
+		buf = append(buf, m.Name...)
+
+		// timestamp, sub-second timestamps are only sent as float
+		if fractional {
+			buf = append(buf, pickleBinFloat)
+			buf = binary.BigEndian.AppendUint64(buf, math.Float64bits(ts))
+		} else if ts >= math.MinInt32 && ts <= math.MaxInt32 {
+			buf = append(buf, pickleBinInt)
+			buf = binary.LittleEndian.AppendUint32(buf, uint32(int32(ts)))
+		} else {
+			buf = append(buf, pickleLong1, 8)
+			buf = binary.LittleEndian.AppendUint64(buf, uint64(int64(ts)))
+		}
+
+		// value
+		buf = append(buf, pickleBinFloat)
+		buf = binary.BigEndian.AppendUint64(buf, math.Float64bits(value))
+
+		buf = append(buf, pickleTuple2, pickleTuple2)
+		encoded++
+	}
+
+	buf = append(buf, pickleAppends, pickleStop)
+
+	return buf, encoded, errs
+}
@@ -0,0 +1,128 @@
+package pirate
+
+import (
+	"bufio"
+	"encoding/hex"
+	"github.com/stretchr/testify/assert"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// pickleTestHex concatenates the opcodes of a pickle given as commented hex strings
+func pickleTestHex(t *testing.T, parts ...string) []byte {
+	var s strings.Builder
+	for _, part := range parts {
+		s.WriteString(strings.Fields(part)[0])
+	}
+
+	b, err := hex.DecodeString(s.String())
+	assert.Nil(t, err)
+
+	return b
+}
+
+func TestEncodePickle(t *testing.T) {
+	fractional := &Metric{Name: []byte("d"), Value: []byte("-1")}
+	fractional.SetTime(time.Unix(1234567890, 250000000), time.Millisecond)
+
+	metrics := []*Metric{
+		{Name: []byte("a.b"), Value: []byte("1.5"), Timestamp: []byte("1234567890")},
+		{Name: []byte("c"), Value: []byte("2"), Timestamp: []byte("4294967296")},
+		fractional,
+	}
+
+	// pickle.loads() of Python returns [('a.b', (1234567890, 1.5)), ('c', (4294967296, 2.0)), ('d', (1234567890.25, -1.0))]
+	expected := pickleTestHex(t,
+		"8002 PROTO 2",
+		"5d EMPTY_LIST",
+		"28 MARK",
+		"5803000000612e62 BINUNICODE 'a.b'",
+		"4ad2029649 BININT 1234567890",
+		"473ff8000000000000 BINFLOAT 1.5",
+		"8686 TUPLE2 TUPLE2",
+		"580100000063 BINUNICODE 'c'",
+		"8a080000000001000000 LONG1 4294967296",
+		"474000000000000000 BINFLOAT 2.0",
+		"8686 TUPLE2 TUPLE2",
+		"580100000064 BINUNICODE 'd'",
+		"4741d26580b4900000 BINFLOAT 1234567890.25",
+		"47bff0000000000000 BINFLOAT -1.0",
+		"8686 TUPLE2 TUPLE2",
+		"65 APPENDS",
+		"2e STOP",
+	)
+
+	payload, encoded, errs := encodePickle(metrics, TimestampNone)
+
+	assert.Equal(t, expected, payload)
+	assert.Equal(t, 3, encoded)
+	assert.Empty(t, errs)
+
+	t.Run("rounded", func(t *testing.T) {
+		payload, _, _ := encodePickle([]*Metric{fractional}, TimestampRound)
+
+		assert.Equal(t, pickleTestHex(t,
+			"80025d28",
+			"580100000064 BINUNICODE 'd'",
+			"4ad2029649 BININT 1234567890",
+			"47bff0000000000000 BINFLOAT -1.0",
+			"8686652e",
+		), payload)
+	})
+
+	t.Run("invalid metrics", func(t *testing.T) {
+		invalid := []*Metric{
+			{Name: []byte("x"), Value: []byte("abc"), Timestamp: []byte("1234567890")},
+			metrics[0],
+			{Name: []byte("y"), Value: []byte("1"), Timestamp: []byte("now")},
+		}
+
+		payload, encoded, errs := encodePickle(invalid, TimestampFloor)
+
+		assert.Equal(t, 1, encoded)
+		assert.Len(t, errs, 2)
+		assert.Equal(t, pickleTestHex(t,
+			"80025d28",
+			"5803000000612e62 BINUNICODE 'a.b'",
+			"4ad2029649 BININT 1234567890",
+			"473ff8000000000000 BINFLOAT 1.5",
+			"8686652e",
+		), payload)
+	})
+}
+
+func TestPickleWriter(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer listener.Close()
+
+	stats := NewMonitoringStats()
+	w, err := NewPickleWriter(listener.Addr().String(), 2, time.Second, TimestampFloor, newTestLogger(), stats)
+	assert.Nil(t, err)
+
+	conn, err := listener.Accept()
+	assert.Nil(t, err)
+	defer conn.Close()
+
+	metric := &Metric{Name: []byte("a.b"), Value: []byte("1.5"), Timestamp: []byte("1234567890")}
+	invalid := &Metric{Name: []byte("x"), Value: []byte("abc"), Timestamp: []byte("1234567890")}
+	assert.Nil(t, w.WriteBatch([]*Metric{metric, invalid}))
+
+	payload, _, _ := encodePickle([]*Metric{metric}, TimestampFloor)
+	frame, err := readLengthPrefixedMessage(bufio.NewReader(conn), 1024)
+
+	assert.Nil(t, err)
+	assert.Equal(t, payload, frame)
+
+	// a batch of invalid metrics only is not sent at all
+	assert.Nil(t, w.WriteBatch([]*Metric{invalid}))
+
+	assert.Nil(t, w.Close())
+	assert.Nil(t, w.Close(), "Close must not fail when called twice")
+
+	counters := stats.Reset()
+	assert.Equal(t, 1, counters["metrics_written"])
+	assert.Equal(t, 2, counters["metrics_dropped"])
+}
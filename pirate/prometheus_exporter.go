@@ -0,0 +1,227 @@
+package pirate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/op/go-logging"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	PrometheusGauge   = "gauge"
+	PrometheusCounter = "counter"
+)
+
+var (
+	invalidPrometheusChars = regexp.MustCompile(`[^a-zA-Z0-9_]`)
+	prometheusEscaper      = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+)
+
+type prometheusSeries struct {
+	name    string
+	labels  string
+	typ     string
+	value   float64
+	updated time.Time
+
+	// timestamp of the latest value sent by the client
+	timestamp time.Time
+}
+
+type PrometheusExporter struct {
+	cfg    *PrometheusConfig
+	server *http.Server
+	logger *logging.Logger
+	chMsg  <-chan *Message
+	series map[string]*prometheusSeries
+	mu     sync.Mutex
+}
+
+func NewPrometheusExporter(cfg *PrometheusConfig, logger *logging.Logger, chMsg <-chan *Message) *PrometheusExporter {
+	e := &PrometheusExporter{cfg: cfg, logger: logger, chMsg: chMsg, series: make(map[string]*prometheusSeries)}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(cfg.Path, e.handleMetrics)
+
+	e.server = &http.Server{
+		Addr:              cfg.Address,
+		Handler:           mux,
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+
+	return e
+}
+
+// Run consumes validated messages until the channel got closed. Stale series are expired every staleness window, so
+// that they do not pile up without scrapes.
+func (e *PrometheusExporter) Run() {
+	e.logger.Info("[Prometheus] Starting prometheus exporter")
+
+	ticker := time.NewTicker(e.cfg.Staleness)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case msg, ok := <-e.chMsg:
+			if !ok {
+				return
+			}
+
+			e.observe(msg)
+		case now := <-ticker.C:
+			e.mu.Lock()
+			e.expire(now)
+			e.mu.Unlock()
+		}
+	}
+}
+
+func (e *PrometheusExporter) Serve() error {
+	e.logger.Infof("[Prometheus] Listening on %s%s", e.server.Addr, e.cfg.Path)
+
+	if err := e.server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return fmt.Errorf("Unable to start prometheus exporter on %s: %s", e.server.Addr, err)
+	}
+
+	return nil
+}
+
+func (e *PrometheusExporter) Stop() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	e.server.Shutdown(ctx)
+}
+
+func (e *PrometheusExporter) observe(msg *Message) {
+	pid := string(msg.Header["project"])
+	labels := prometheusLabels(msg.Header, msg.Project.PrometheusLabels)
+	now := time.Now()
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	for _, metric := range msg.Metrics {
+		value, err := strconv.ParseFloat(string(metric.Value), 64)
+		if err != nil {
+			continue
+		}
+
+		typ := PrometheusGauge
+		if metricCfg, exists := msg.Project.Metrics[string(metric.Name)]; exists && metricCfg.PrometheusType != "" {
+			typ = metricCfg.PrometheusType
+		}
+
+		name := prometheusName(pid, string(metric.Name))
+		key := name + labels
+
+		series, exists := e.series[key]
+		if !exists || series.typ != typ {
+			series = &prometheusSeries{name: name, labels: labels, typ: typ}
+			e.series[key] = series
+		}
+
+		// gauges expose the latest value, counters the sum of all received values
+		if typ == PrometheusCounter {
+			series.value += value
+		} else {
+			series.value = value
+		}
+		series.updated = now
+
+		if ts, err := strconv.ParseInt(string(metric.Timestamp), 10, 64); err == nil {
+			series.timestamp = time.Unix(ts, int64(metric.Nanos))
+		}
+	}
+}
+
+// expire removes the series, which did not receive any value within the staleness window. The caller must hold mu.
+func (e *PrometheusExporter) expire(now time.Time) {
+	staleTime := now.Add(-e.cfg.Staleness)
+
+	for key, s := range e.series {
+		if s.updated.Before(staleTime) {
+			delete(e.series, key)
+		}
+	}
+}
+
+func (e *PrometheusExporter) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	e.mu.Lock()
+	e.expire(time.Now())
+
+	series := make([]*prometheusSeries, 0, len(e.series))
+	for _, s := range e.series {
+		copied := *s
+		series = append(series, &copied)
+	}
+	e.mu.Unlock()
+
+	sort.Slice(series, func(i, j int) bool {
+		if series[i].name != series[j].name {
+			return series[i].name < series[j].name
+		}
+		return series[i].labels < series[j].labels
+	})
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+
+	prevName := ""
+	for _, s := range series {
+		if s.name != prevName {
+			fmt.Fprintf(w, "# TYPE %s %s\n", s.name, s.typ)
+			prevName = s.name
+		}
+
+		// timestamps are optional in milliseconds, otherwise prometheus uses the scrape time
+		if e.cfg.Timestamps {
+			fmt.Fprintf(w, "%s%s %s %d\n", s.name, s.labels, strconv.FormatFloat(s.value, 'g', -1, 64), s.timestamp.UnixMilli())
+		} else {
+			fmt.Fprintf(w, "%s%s %s\n", s.name, s.labels, strconv.FormatFloat(s.value, 'g', -1, 64))
+		}
+	}
+}
+
+func prometheusName(pid string, name string) string {
+	result := invalidPrometheusChars.ReplaceAllString(pid+"_"+name, "_")
+	if result[0] >= '0' && result[0] <= '9' {
+		result = "_" + result
+	}
+
+	return result
+}
+
+func prometheusLabels(header map[string][]byte, labels []string) string {
+	var b strings.Builder
+
+	for _, label := range labels {
+		value, ok := header[label]
+		if !ok {
+			continue
+		}
+
+		if b.Len() == 0 {
+			b.WriteByte('{')
+		} else {
+			b.WriteByte(',')
+		}
+
+		b.WriteString(label)
+		b.WriteString(`="`)
+		b.WriteString(prometheusEscaper.Replace(string(value)))
+		b.WriteByte('"')
+	}
+
+	if b.Len() > 0 {
+		b.WriteByte('}')
+	}
+
+	return b.String()
+}
@@ -0,0 +1,120 @@
+package pirate
+
+import (
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+var prometheusTestProject = &ProjectConfig{
+	PrometheusLabels: []string{"platform", "version"},
+	Metrics: map[string]*MetricConfig{
+		"fps":    {},
+		"errors": {PrometheusType: PrometheusCounter},
+	},
+}
+
+func newPrometheusTestMessage(platform string, metrics ...*Metric) *Message {
+	return &Message{
+		Header:  map[string][]byte{"project": []byte("awesome-game"), "platform": []byte(platform), "other": []byte("x")},
+		Metrics: metrics,
+		Project: prometheusTestProject,
+	}
+}
+
+func scrape(e *PrometheusExporter) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	e.server.Handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, e.cfg.Path, nil))
+
+	return w
+}
+
+func TestPrometheusExporter(t *testing.T) {
+	cfg := &PrometheusConfig{Path: "/metrics", Staleness: time.Minute}
+	e := NewPrometheusExporter(cfg, newTestLogger(), nil)
+
+	e.observe(newPrometheusTestMessage("ios",
+		&Metric{Name: []byte("fps"), Value: []byte("30"), Timestamp: []byte("1234567890")},
+		&Metric{Name: []byte("errors"), Value: []byte("2"), Timestamp: []byte("1234567890")},
+	))
+	e.observe(newPrometheusTestMessage(`an"droid`,
+		&Metric{Name: []byte("fps"), Value: []byte("55"), Timestamp: []byte("1234567891")},
+	))
+	e.observe(newPrometheusTestMessage("ios",
+		&Metric{Name: []byte("fps"), Value: []byte("1.5e3"), Timestamp: []byte("1234567892"), Nanos: 250000000},
+		&Metric{Name: []byte("errors"), Value: []byte("3"), Timestamp: []byte("1234567892")},
+	))
+
+	w := scrape(e)
+
+	// gauges expose the latest value, counters the sum of all values
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "text/plain; version=0.0.4; charset=utf-8", w.Header().Get("Content-Type"))
+	assert.Equal(t, `# TYPE awesome_game_errors counter
+awesome_game_errors{platform="ios"} 5
+# TYPE awesome_game_fps gauge
+awesome_game_fps{platform="an\"droid"} 55
+awesome_game_fps{platform="ios"} 1500
+`, w.Body.String())
+
+	t.Run("timestamps", func(t *testing.T) {
+		cfg.Timestamps = true
+		defer func() { cfg.Timestamps = false }()
+
+		assert.Contains(t, scrape(e).Body.String(), `awesome_game_fps{platform="ios"} 1500 1234567892250`+"\n")
+	})
+}
+
+func TestPrometheusExporterTypeChange(t *testing.T) {
+	e := NewPrometheusExporter(&PrometheusConfig{Path: "/metrics", Staleness: time.Minute}, newTestLogger(), nil)
+	project := &ProjectConfig{Metrics: map[string]*MetricConfig{"fps": {PrometheusType: PrometheusCounter}}}
+
+	msg := &Message{Header: map[string][]byte{"project": []byte("p")}, Project: project}
+	msg.Metrics = []*Metric{{Name: []byte("fps"), Value: []byte("2"), Timestamp: []byte("1234567890")}}
+	e.observe(msg)
+	e.observe(msg)
+
+	// a reloaded type starts a new series
+	msg.Project = &ProjectConfig{Metrics: map[string]*MetricConfig{"fps": {}}}
+	e.observe(msg)
+
+	assert.Equal(t, "# TYPE p_fps gauge\np_fps 2\n", scrape(e).Body.String())
+}
+
+func TestPrometheusExporterStaleness(t *testing.T) {
+	chMsg := make(chan *Message)
+	e := NewPrometheusExporter(&PrometheusConfig{Path: "/metrics", Staleness: 50 * time.Millisecond}, newTestLogger(), chMsg)
+
+	chDone := make(chan struct{})
+	go func() {
+		e.Run()
+		close(chDone)
+	}()
+
+	chMsg <- newPrometheusTestMessage("ios", &Metric{Name: []byte("fps"), Value: []byte("30"), Timestamp: []byte("1234567890")})
+
+	// stale series are removed without any scrape
+	assert.Eventually(t, func() bool {
+		e.mu.Lock()
+		defer e.mu.Unlock()
+
+		return len(e.series) == 0
+	}, time.Second, 10*time.Millisecond)
+
+	close(chMsg)
+	<-chDone
+
+	// and are not exposed by a scrape before they got expired
+	e.observe(newPrometheusTestMessage("ios", &Metric{Name: []byte("fps"), Value: []byte("30"), Timestamp: []byte("1234567890")}))
+	e.series[`awesome_game_fps{platform="ios"}`].updated = time.Now().Add(-time.Minute)
+
+	assert.Empty(t, scrape(e).Body.String())
+	assert.Empty(t, e.series)
+}
+
+func TestPrometheusName(t *testing.T) {
+	assert.Equal(t, "awesome_game_fps_avg", prometheusName("awesome-game", "fps.avg"))
+	assert.Equal(t, "_1game_fps", prometheusName("1game", "fps"))
+}
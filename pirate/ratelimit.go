@@ -38,17 +38,25 @@ func (l *IpLimiter) Allow(ip net.IP) bool {
 }
 
 func (l *IpLimiter) AllowN(ip net.IP, n int) bool {
+	return l.AllowKeyN(ip.String(), n)
+}
+
+// AllowKey limits clients without IP address, e.g. peers of unix sockets, by an arbitrary key
+func (l *IpLimiter) AllowKey(key string) bool {
+	return l.AllowKeyN(key, 1)
+}
+
+func (l *IpLimiter) AllowKeyN(key string, n int) bool {
 	l.mu.Lock()
 
-	ipStr := ip.String()
-	info, ok := l.lookup[ipStr]
+	info, ok := l.lookup[key]
 	now := time.Now()
 
 	// make sure entry exists
 	if !ok {
-		if info, ok = l.lookup[ipStr]; !ok {
+		if info, ok = l.lookup[key]; !ok {
 			info = &LimitInfo{now, 0}
-			l.lookup[ipStr] = info
+			l.lookup[key] = info
 		}
 	}
 
@@ -59,9 +67,10 @@ func (l *IpLimiter) AllowN(ip net.IP, n int) bool {
 	}
 
 	info.count += n
+	allowed := info.count <= l.max
 	l.mu.Unlock()
 
-	return info.count <= l.max
+	return allowed
 }
 
 func (l *IpLimiter) runGcLoop() {
@@ -110,7 +119,8 @@ func (l *Limiter) AllowN(n int) bool {
 	}
 
 	l.info.count += n
+	allowed := l.info.count <= l.max
 	l.mu.Unlock()
 
-	return l.info.count <= l.max
+	return allowed
 }
@@ -0,0 +1,180 @@
+package pirate
+
+import (
+	"bytes"
+	"github.com/op/go-logging"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+type SharedConfig struct {
+	cfg atomic.Pointer[Config]
+}
+
+func NewSharedConfig(cfg *Config) *SharedConfig {
+	shared := &SharedConfig{}
+	shared.cfg.Store(cfg)
+
+	return shared
+}
+
+func (s *SharedConfig) Load() *Config {
+	return s.cfg.Load()
+}
+
+func (s *SharedConfig) Store(cfg *Config) {
+	s.cfg.Store(cfg)
+}
+
+type ConfigReloader struct {
+	filename string
+	shared   *SharedConfig
+	logger   *logging.Logger
+	stats    *MonitoringStats
+	hooks    []func(cfg *Config)
+	mu       sync.Mutex
+}
+
+func NewConfigReloader(filename string, shared *SharedConfig, logger *logging.Logger, stats *MonitoringStats) *ConfigReloader {
+	return &ConfigReloader{filename: filename, shared: shared, logger: logger, stats: stats}
+}
+
+// OnReload registers a callback, which is called with the new config after every successful reload.
+func (r *ConfigReloader) OnReload(hook func(cfg *Config)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.hooks = append(r.hooks, hook)
+}
+
+// Reload loads the config file into a fresh config and swaps it in. If the file is invalid,
+// the currently active config stays in place.
+func (r *ConfigReloader) Reload() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.logger.Infof("[Reload] Reloading configuration from %s", r.filename)
+
+	cfg, err := LoadConfig(r.filename)
+	if err != nil {
+		r.logger.Errorf("[Reload] Keeping current configuration: %s", err)
+		r.stats.IncConfigReloadFailed()
+
+		return err
+	}
+
+	old := r.shared.Load()
+	for _, key := range restartOnlyChanges(old, cfg) {
+		r.logger.Warningf(`[Reload] Changes of "%s" require a restart and are ignored`, key)
+	}
+
+	r.shared.Store(cfg)
+	r.stats.IncConfigReloaded()
+
+	for _, hook := range r.hooks {
+		hook(cfg)
+	}
+
+	cfg.Log(r.logger)
+
+	return nil
+}
+
+func restartOnlyChanges(old *Config, cfg *Config) []string {
+	var keys []string
+
+	if old.UdpConfig != cfg.UdpConfig || !equalUdpListeners(old.UdpListeners, cfg.UdpListeners) {
+		keys = append(keys, "udp_*")
+	}
+	if *old.Http != *cfg.Http {
+		keys = append(keys, "http")
+	}
+	if *old.Tcp != *cfg.Tcp {
+		keys = append(keys, "tcp")
+	}
+	if *old.Unix != *cfg.Unix {
+		keys = append(keys, "unix")
+	}
+	if old.GraphiteTarget != cfg.GraphiteTarget {
+		keys = append(keys, "graphite_target")
+	}
+	if !equalOutputs(old.Outputs, cfg.Outputs) {
+		keys = append(keys, "outputs")
+	}
+	if !equalOutputs(old.ProjectOutputs, cfg.ProjectOutputs) {
+		keys = append(keys, "projects.*.graphite_target")
+	}
+	if old.Compression != cfg.Compression {
+		keys = append(keys, "compression")
+	}
+	if *old.Decompression != *cfg.Decompression {
+		keys = append(keys, "decompression")
+	}
+	if !equalDictionaries(old.ZstdDictionaries, cfg.ZstdDictionaries) {
+		keys = append(keys, "projects.*.zstd_dictionaries")
+	}
+	if *old.PerIpRateLimit != *cfg.PerIpRateLimit {
+		keys = append(keys, "per_ip_ratelimit")
+	}
+	if *old.WriterRetry != *cfg.WriterRetry {
+		keys = append(keys, "writer_retry")
+	}
+	if *old.Spill != *cfg.Spill {
+		keys = append(keys, "spill")
+	}
+	if *old.Prometheus != *cfg.Prometheus {
+		keys = append(keys, "prometheus")
+	}
+	if old.ShutdownTimeout != cfg.ShutdownTimeout {
+		keys = append(keys, "shutdown_timeout")
+	}
+	if old.AdminAddress != cfg.AdminAddress {
+		keys = append(keys, "admin_address")
+	}
+
+	return keys
+}
+
+func equalOutputs(old []*OutputConfig, outputs []*OutputConfig) bool {
+	if len(old) != len(outputs) {
+		return false
+	}
+
+	for i := range old {
+		if old[i].Name != outputs[i].Name || old[i].Target != outputs[i].Target || old[i].PathPattern != outputs[i].PathPattern ||
+			old[i].DeadLetterFile != outputs[i].DeadLetterFile || old[i].BlockWhenFull != outputs[i].BlockWhenFull || strings.Join(old[i].Projects, ",") != strings.Join(outputs[i].Projects, ",") {
+			return false
+		}
+	}
+
+	return true
+}
+
+func equalUdpListeners(old []*UdpListenerConfig, listeners []*UdpListenerConfig) bool {
+	if len(old) != len(listeners) {
+		return false
+	}
+
+	for i := range old {
+		if old[i].Address != listeners[i].Address || old[i].MessageFormat != listeners[i].MessageFormat || strings.Join(old[i].Projects, ",") != strings.Join(listeners[i].Projects, ",") {
+			return false
+		}
+	}
+
+	return true
+}
+
+func equalDictionaries(old map[uint32][]byte, dictionaries map[uint32][]byte) bool {
+	if len(old) != len(dictionaries) {
+		return false
+	}
+
+	for id, dict := range old {
+		if !bytes.Equal(dict, dictionaries[id]) {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,118 @@
+package pirate
+
+import (
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const reloadTestConfig = `
+graphite_target: tcp://127.0.0.1:3002
+projects:
+  p:
+    graphite_path: p.{metric.name}
+    metrics:
+      fps: {min: 0, max: 100}
+`
+
+func newTestReloader(t *testing.T, content string) (*ConfigReloader, string) {
+	filename := filepath.Join(t.TempDir(), "config.yml")
+	assert.Nil(t, os.WriteFile(filename, []byte(content), 0644))
+
+	cfg, err := LoadConfig(filename)
+	assert.Nil(t, err)
+
+	return NewConfigReloader(filename, NewSharedConfig(cfg), newTestLogger(), NewMonitoringStats()), filename
+}
+
+func TestConfigReloader(t *testing.T) {
+	r, filename := newTestReloader(t, reloadTestConfig)
+	old := r.shared.Load()
+
+	var reloaded *Config
+	r.OnReload(func(cfg *Config) { reloaded = cfg })
+
+	// projects are reloaded, the changed target requires a restart but does not fail the reload
+	assert.Nil(t, os.WriteFile(filename, []byte(`
+graphite_target: tcp://127.0.0.1:3003
+projects:
+  p:
+    graphite_path: p.{metric.name}
+    metrics:
+      fps: {min: 0, max: 60}
+      memory: {min: 0, max: 1024}
+`), 0644))
+
+	assert.Nil(t, r.Reload())
+
+	cfg := r.shared.Load()
+	assert.NotSame(t, old, cfg)
+	assert.Same(t, cfg, reloaded)
+	assert.Contains(t, cfg.Projects["p"].Metrics, "memory")
+	assert.Equal(t, float64(100), old.Projects["p"].Metrics["fps"].Max, "The old config must stay untouched")
+	assert.Equal(t, 1, r.stats.Reset()["config_reloaded"])
+
+	t.Run("invalid file", func(t *testing.T) {
+		reloaded = nil
+		assert.Nil(t, os.WriteFile(filename, []byte("projects:\n  p:\n    graphite_path: p.{metric.name}\n    attributes: {a: \"(\"}\n"), 0644))
+
+		assert.NotNil(t, r.Reload())
+
+		assert.Same(t, cfg, r.shared.Load(), "The active config must stay in place")
+		assert.Nil(t, reloaded, "Hooks must not be called")
+		assert.Equal(t, 1, r.stats.Reset()["config_reload_failed"])
+	})
+}
+
+func TestRestartOnlyChanges(t *testing.T) {
+	old, err := loadTestConfig(t, reloadTestConfig)
+	assert.Nil(t, err)
+
+	t.Run("projects only", func(t *testing.T) {
+		cfg, err := loadTestConfig(t, reloadTestConfig+`
+  other:
+    graphite_path: other.{metric.name}
+    metrics:
+      fps: {min: 0, max: 100}
+`)
+		assert.Nil(t, err)
+
+		assert.Empty(t, restartOnlyChanges(old, cfg))
+	})
+
+	t.Run("restart required", func(t *testing.T) {
+		cfg, err := loadTestConfig(t, `
+graphite_target: tcp://127.0.0.1:3003
+udp_listeners:
+  - address: 0.0.0.0:33334
+compression: zstd
+per_ip_ratelimit: {amount: 1, interval: 1s}
+writer_retry: {max_attempts: 1}
+admin_address: 127.0.0.1:9338
+projects:
+  p:
+    graphite_path: p.{metric.name}
+    graphite_target: tcp://127.0.0.1:3004
+    metrics:
+      fps: {min: 0, max: 100}
+`)
+		assert.Nil(t, err)
+
+		assert.Equal(t, []string{"udp_*", "graphite_target", "outputs", "projects.*.graphite_target", "compression", "per_ip_ratelimit", "writer_retry", "admin_address"}, restartOnlyChanges(old, cfg))
+	})
+
+	t.Run("listener projects", func(t *testing.T) {
+		listeners := []*UdpListenerConfig{{Address: "0.0.0.0:33333", Projects: []string{"p"}}}
+
+		assert.True(t, equalUdpListeners(listeners, []*UdpListenerConfig{{Address: "0.0.0.0:33333", Projects: []string{"p"}}}))
+		assert.False(t, equalUdpListeners(listeners, []*UdpListenerConfig{{Address: "0.0.0.0:33333"}}))
+		assert.False(t, equalUdpListeners(listeners, []*UdpListenerConfig{{Address: "0.0.0.0:33333", Projects: []string{"p"}, MessageFormat: MessageFormatJSON}}))
+	})
+
+	t.Run("dictionaries", func(t *testing.T) {
+		assert.True(t, equalDictionaries(map[uint32][]byte{1: []byte("a")}, map[uint32][]byte{1: []byte("a")}))
+		assert.False(t, equalDictionaries(map[uint32][]byte{1: []byte("a")}, map[uint32][]byte{1: []byte("b")}))
+		assert.False(t, equalDictionaries(map[uint32][]byte{1: []byte("a")}, map[uint32][]byte{2: []byte("a")}))
+	})
+}
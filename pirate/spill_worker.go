@@ -0,0 +1,371 @@
+package pirate
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/op/go-logging"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	SpillPollInterval  = 100 * time.Millisecond
+	spillSegmentSuffix = ".seg"
+)
+
+type spillSegment struct {
+	path    string
+	created time.Time
+	size    int64
+	count   int
+}
+
+type spillWorker struct {
+	cfg      *SpillConfig
+	logger   *logging.Logger
+	stats    *MonitoringStats
+	chIn     <-chan *Metric
+	chOut    chan<- *Metric
+	breaker  *circuitBreaker
+	segments []*spillSegment
+	active   *spillSegment
+	file     *os.File
+	size     int64
+	draining bool
+	mu       sync.Mutex
+}
+
+// NewSpillWorker creates a spill worker, which spills and stops draining while the circuit breaker of the writer is
+// open, so that metrics queue up on disk instead of failing during an outage. The breaker is optional.
+func NewSpillWorker(cfg *SpillConfig, breaker *circuitBreaker, logger *logging.Logger, stats *MonitoringStats, chIn <-chan *Metric, chOut chan<- *Metric) (*spillWorker, error) {
+	if err := os.MkdirAll(cfg.Directory, 0755); err != nil {
+		return nil, fmt.Errorf("Failed to create spill directory %s: %s", cfg.Directory, err)
+	}
+
+	w := &spillWorker{cfg: cfg, breaker: breaker, logger: logger, stats: stats, chIn: chIn, chOut: chOut}
+
+	// pick up segments of a previous run
+	paths, err := filepath.Glob(filepath.Join(cfg.Directory, "*"+spillSegmentSuffix))
+	if err != nil {
+		return nil, fmt.Errorf("Failed to list spill directory %s: %s", cfg.Directory, err)
+	}
+	sort.Strings(paths)
+
+	for _, path := range paths {
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to read spill segment %s: %s", path, err)
+		}
+
+		nanos, err := strconv.ParseInt(strings.TrimSuffix(filepath.Base(path), spillSegmentSuffix), 10, 64)
+		if err != nil {
+			logger.Warningf("[Spill] Ignoring unknown file %s", path)
+			continue
+		}
+
+		segment := &spillSegment{path, time.Unix(0, nanos), int64(len(content)), bytes.Count(content, []byte{'\n'})}
+		w.segments = append(w.segments, segment)
+		w.size += segment.size
+	}
+
+	if len(w.segments) > 0 {
+		logger.Infof("[Spill] Found %d segments with %d bytes from previous run", len(w.segments), w.size)
+	}
+
+	return w, nil
+}
+
+func (w *spillWorker) Run() {
+	w.logger.Infof("[Spill] Starting spill worker in %s", w.cfg.Directory)
+
+	chStop := make(chan struct{})
+	chDrained := make(chan struct{})
+
+	go func() {
+		w.drain(chStop)
+		close(chDrained)
+	}()
+
+	for metric := range w.chIn {
+		w.mu.Lock()
+
+		// pass through directly as long as nothing is queued on disk, otherwise the order would break
+		if len(w.segments) == 0 && w.active == nil && !w.draining && w.targetReady() {
+			select {
+			case w.chOut <- metric:
+				w.mu.Unlock()
+				continue
+			default:
+			}
+		}
+
+		if err := w.spill(metric); err != nil {
+			w.logger.Errorf("[Spill] %s", err)
+			w.stats.IncMetricsSpillDropped(1)
+		}
+
+		w.mu.Unlock()
+	}
+
+	// remaining segments stay on disk and are drained after the next start
+	close(chStop)
+	<-chDrained
+
+	w.mu.Lock()
+	w.rotate()
+	w.mu.Unlock()
+}
+
+func (w *spillWorker) spill(metric *Metric) error {
+	line := spillLine(metric)
+
+	// make room by dropping the oldest segments
+	for w.size+int64(len(line)) > w.cfg.MaxSize && len(w.segments) > 0 {
+		w.dropSegment(w.segments[0], "size limit reached")
+		w.segments = w.segments[1:]
+	}
+
+	if w.size+int64(len(line)) > w.cfg.MaxSize {
+		return fmt.Errorf("Spill queue is full, dropping metric %s", metric.Name)
+	}
+
+	if w.active == nil {
+		created := time.Now()
+		path := filepath.Join(w.cfg.Directory, fmt.Sprintf("%020d%s", created.UnixNano(), spillSegmentSuffix))
+
+		file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE|os.O_EXCL, 0644)
+		if err != nil {
+			return fmt.Errorf("Failed to create spill segment %s: %s", path, err)
+		}
+
+		w.active = &spillSegment{path: path, created: created}
+		w.file = file
+	}
+
+	if _, err := w.file.Write(line); err != nil {
+		return fmt.Errorf("Failed to write spill segment %s: %s", w.active.path, err)
+	}
+
+	w.active.size += int64(len(line))
+	w.active.count++
+	w.size += int64(len(line))
+	w.stats.IncMetricsSpilled()
+
+	if w.active.size >= w.cfg.SegmentSize {
+		w.rotate()
+	}
+
+	return nil
+}
+
+// rotate closes the active segment and queues it for draining.
+func (w *spillWorker) rotate() {
+	if w.active == nil {
+		return
+	}
+
+	w.file.Close()
+	w.segments = append(w.segments, w.active)
+	w.active = nil
+	w.file = nil
+}
+
+func (w *spillWorker) dropSegment(segment *spillSegment, reason string) {
+	w.logger.Warningf("[Spill] Dropping segment %s with %d metrics: %s", segment.path, segment.count, reason)
+
+	if err := os.Remove(segment.path); err != nil {
+		w.logger.Errorf("[Spill] Failed to remove segment: %s", err)
+	}
+
+	w.size -= segment.size
+	w.stats.IncMetricsSpillDropped(segment.count)
+}
+
+func (w *spillWorker) next() *spillSegment {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if len(w.segments) == 0 {
+		w.rotate()
+	}
+
+	for len(w.segments) > 0 {
+		segment := w.segments[0]
+		w.segments = w.segments[1:]
+
+		if time.Since(segment.created) > w.cfg.MaxAge {
+			w.dropSegment(segment, "max age exceeded")
+			continue
+		}
+
+		w.draining = true
+
+		return segment
+	}
+
+	return nil
+}
+
+func (w *spillWorker) drain(chStop <-chan struct{}) {
+	for {
+		segment := w.next()
+		if segment == nil {
+			select {
+			case <-chStop:
+				return
+			case <-time.After(SpillPollInterval):
+				continue
+			}
+		}
+
+		done := w.drainSegment(segment, chStop)
+
+		w.mu.Lock()
+		w.draining = false
+		w.mu.Unlock()
+
+		if !done {
+			return
+		}
+	}
+}
+
+func (w *spillWorker) drainSegment(segment *spillSegment, chStop <-chan struct{}) bool {
+	content, err := os.ReadFile(segment.path)
+	if err != nil {
+		w.logger.Errorf("[Spill] Failed to read segment %s: %s", segment.path, err)
+
+		w.mu.Lock()
+		w.dropSegment(segment, "unreadable")
+		w.mu.Unlock()
+
+		return true
+	}
+
+	w.logger.Infof("[Spill] Draining segment %s with %d metrics", segment.path, segment.count)
+
+	lines := bytes.SplitAfter(content, []byte{'\n'})
+	for i, line := range lines {
+		metric, ok := parseSpillLine(line)
+		if !ok {
+			continue
+		}
+
+		if w.waitForTarget(chStop) {
+			select {
+			case w.chOut <- metric:
+				w.stats.IncMetricsUnspilled()
+				continue
+			case <-chStop:
+			}
+		}
+
+		// keep the rest for the next start
+		w.mu.Lock()
+		defer w.mu.Unlock()
+
+		rest := bytes.Join(lines[i:], nil)
+		if err := os.WriteFile(segment.path, rest, 0644); err != nil {
+			w.logger.Errorf("[Spill] Failed to rewrite segment %s: %s", segment.path, err)
+		}
+
+		w.size -= segment.size - int64(len(rest))
+		w.segments = append([]*spillSegment{{segment.path, segment.created, int64(len(rest)), bytes.Count(rest, []byte{'\n'})}}, w.segments...)
+
+		return false
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if err := os.Remove(segment.path); err != nil {
+		w.logger.Errorf("[Spill] Failed to remove segment %s: %s", segment.path, err)
+	}
+	w.size -= segment.size
+
+	return true
+}
+
+// targetReady reports whether the writer may be fed, which is not the case while the target is down and not due to be
+// probed
+func (w *spillWorker) targetReady() bool {
+	return w.breaker == nil || w.breaker.ready()
+}
+
+// waitForTarget pauses draining while the target is down and reports false, if the worker got stopped meanwhile
+func (w *spillWorker) waitForTarget(chStop <-chan struct{}) bool {
+	for !w.targetReady() {
+		select {
+		case <-chStop:
+			return false
+		case <-time.After(SpillPollInterval):
+		}
+	}
+
+	return true
+}
+
+// spillLine extends the Graphite line by the project, original metric name and attributes,
+// so that non-Graphite formats can still be written after draining: "path value ts project key a=1;b=2"
+func spillLine(m *Metric) []byte {
+	line := graphiteLine(m.Name, m.Value, fractionalTimestamp(m))
+	if len(m.Key) == 0 {
+		return line
+	}
+
+	line = append(line[:len(line)-1], ' ')
+	line = append(line, m.Project...)
+	line = append(line, ' ')
+	line = append(line, m.Key...)
+	line = append(line, ' ')
+
+	if len(m.Attributes) == 0 {
+		line = append(line, '-')
+	}
+
+	i := 0
+	for key, value := range m.Attributes {
+		if i > 0 {
+			line = append(line, ';')
+		}
+		line = append(line, key...)
+		line = append(line, '=')
+		line = append(line, value...)
+		i++
+	}
+
+	return append(line, '\n')
+}
+
+func parseSpillLine(line []byte) (*Metric, bool) {
+	fields := bytes.Fields(line)
+
+	switch len(fields) {
+	case 3:
+		metric := &Metric{Name: fields[0], Value: fields[1]}
+		return metric, parseFractionalTimestamp(metric, fields[2])
+	case 6:
+		metric := &Metric{Name: fields[0], Value: fields[1], Project: string(fields[3]), Key: fields[4]}
+		if !parseFractionalTimestamp(metric, fields[2]) {
+			return nil, false
+		}
+		metric.Attributes = make(map[string][]byte)
+
+		if !bytes.Equal(fields[5], []byte("-")) {
+			for _, pair := range bytes.Split(fields[5], []byte{';'}) {
+				if key, value, ok := bytes.Cut(pair, []byte{'='}); ok {
+					metric.Attributes[string(key)] = value
+				}
+			}
+		}
+
+		return metric, true
+	default:
+		return nil, false
+	}
+}
@@ -0,0 +1,182 @@
+package pirate
+
+import (
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func newTestSpillWorker(t *testing.T, cfg *SpillConfig, breaker *circuitBreaker, chIn <-chan *Metric, chOut chan<- *Metric) *spillWorker {
+	if cfg.Directory == "" {
+		cfg.Directory = t.TempDir()
+	}
+
+	w, err := NewSpillWorker(cfg, breaker, newTestLogger(), NewMonitoringStats(), chIn, chOut)
+	assert.Nil(t, err)
+
+	return w
+}
+
+func TestSpillLine(t *testing.T) {
+	m := newInfluxTestMetric()
+	m.Attributes = map[string][]byte{"platform": []byte("ios")}
+
+	assert.Equal(t, "games.awesome_game.ios.fps 55 1234567890 awesome_game fps platform=ios\n", string(spillLine(m)))
+
+	m.Attributes = nil
+	assert.Equal(t, "games.awesome_game.ios.fps 55 1234567890 awesome_game fps -\n", string(spillLine(m)))
+
+	monitoring := NewMetric("pirate.metrics_received", 10, time.Unix(1234567890, 0))
+	monitoring.SetTime(time.Unix(1234567890, 250000000), time.Millisecond)
+	assert.Equal(t, "pirate.metrics_received 10 1234567890.250\n", string(spillLine(monitoring)))
+}
+
+func TestParseSpillLine(t *testing.T) {
+	m, ok := parseSpillLine([]byte("games.awesome_game.ios.fps 55 1234567890 awesome_game fps platform=ios;project=awesome_game\n"))
+
+	assert.True(t, ok)
+	assert.Equal(t, newInfluxTestMetric(), m)
+
+	m, ok = parseSpillLine([]byte("games.awesome_game.ios.fps 55 1234567890 awesome_game fps -\n"))
+	assert.True(t, ok)
+	assert.Empty(t, m.Attributes)
+
+	m, ok = parseSpillLine([]byte("pirate.metrics_received 10 1234567890.250\n"))
+	assert.True(t, ok)
+	assert.Equal(t, 250000000, m.Nanos)
+	assert.Equal(t, time.Millisecond, m.Precision)
+
+	for _, line := range []string{"", "malformed\n", "a 1 1234567890.\n", "a 1 1234567890 p k\n", "a 1 1234567890.x p k -\n"} {
+		_, ok := parseSpillLine([]byte(line))
+		assert.False(t, ok, line)
+	}
+}
+
+func TestSpillSegments(t *testing.T) {
+	metric := NewMetric("a", 1, time.Unix(1234567890, 0))
+	line := spillLine(metric)
+
+	cfg := &SpillConfig{SegmentSize: int64(2 * len(line)), MaxSize: int64(5 * len(line)), MaxAge: time.Hour}
+	w := newTestSpillWorker(t, cfg, nil, nil, nil)
+
+	for i := 0; i < 5; i++ {
+		assert.Nil(t, w.spill(metric))
+	}
+
+	// full segments are rotated
+	assert.Len(t, w.segments, 2)
+	assert.Equal(t, 2, w.segments[0].count)
+	assert.Equal(t, int64(2*len(line)), w.segments[0].size)
+	assert.Equal(t, 1, w.active.count)
+	assert.Equal(t, int64(5*len(line)), w.size)
+
+	// the oldest segment is dropped to make room
+	oldest := w.segments[0].path
+	assert.Nil(t, w.spill(metric))
+
+	_, err := os.Stat(oldest)
+	assert.True(t, os.IsNotExist(err))
+	assert.Equal(t, int64(4*len(line)), w.size)
+	assert.Equal(t, 2, w.stats.Reset()["metrics_spill_dropped"])
+
+	// segments are picked up after a restart
+	w.rotate()
+	assert.Nil(t, os.WriteFile(filepath.Join(cfg.Directory, "unknown.seg"), line, 0644))
+
+	restarted := newTestSpillWorker(t, cfg, nil, nil, nil)
+	assert.Len(t, restarted.segments, 2)
+	assert.Equal(t, 2, restarted.segments[1].count)
+	assert.Equal(t, int64(4*len(line)), restarted.size)
+
+	t.Run("metric larger than the spill queue", func(t *testing.T) {
+		w := newTestSpillWorker(t, &SpillConfig{SegmentSize: 1024, MaxSize: 1}, nil, nil, nil)
+
+		assert.ErrorContains(t, w.spill(metric), "Spill queue is full")
+	})
+}
+
+func TestSpillMaxAge(t *testing.T) {
+	w := newTestSpillWorker(t, &SpillConfig{SegmentSize: 1024, MaxSize: 1024, MaxAge: time.Hour}, nil, nil, nil)
+
+	assert.Nil(t, w.spill(NewMetric("a", 1, time.Unix(1234567890, 0))))
+	w.rotate()
+	w.segments[0].created = time.Now().Add(-2 * time.Hour)
+
+	assert.Nil(t, w.next())
+	assert.Equal(t, int64(0), w.size)
+	assert.Equal(t, 1, w.stats.Reset()["metrics_spill_dropped"])
+}
+
+func TestSpillWorker(t *testing.T) {
+	chIn := make(chan *Metric)
+	chOut := make(chan *Metric, 1)
+	breaker := &circuitBreaker{cooldown: time.Hour}
+	w := newTestSpillWorker(t, &SpillConfig{SegmentSize: 1024, MaxSize: 1024, MaxAge: time.Hour}, breaker, chIn, chOut)
+
+	chDone := make(chan struct{})
+	go func() {
+		w.Run()
+		close(chDone)
+	}()
+
+	// metrics pass through as long as the writer keeps up
+	chIn <- NewMetric("a", 1, time.Unix(1234567890, 0))
+	assert.Equal(t, []byte("a"), (<-chOut).Name)
+
+	// metrics are spilled and not drained while the target is down
+	breaker.failed()
+	chIn <- NewMetric("b", 2, time.Unix(1234567890, 0))
+	chIn <- NewMetric("c", 3, time.Unix(1234567890, 0))
+
+	time.Sleep(3 * SpillPollInterval)
+	assert.Len(t, chOut, 0)
+	assert.Equal(t, 2, w.stats.Reset()["metrics_spilled"])
+
+	// draining continues in order once the target is back
+	breaker.succeeded()
+	assert.Equal(t, []byte("b"), (<-chOut).Name)
+	assert.Equal(t, []byte("c"), (<-chOut).Name)
+
+	close(chIn)
+	<-chDone
+
+	assert.Equal(t, int64(0), w.size)
+	paths, err := filepath.Glob(filepath.Join(w.cfg.Directory, "*"+spillSegmentSuffix))
+	assert.Nil(t, err)
+	assert.Empty(t, paths)
+}
+
+func TestSpillWorkerKeepsRestOnStop(t *testing.T) {
+	chIn := make(chan *Metric)
+	breaker := &circuitBreaker{cooldown: time.Hour}
+	breaker.failed()
+
+	cfg := &SpillConfig{SegmentSize: 1024, MaxSize: 1024, MaxAge: time.Hour}
+	w := newTestSpillWorker(t, cfg, breaker, chIn, make(chan *Metric))
+
+	chDone := make(chan struct{})
+	go func() {
+		w.Run()
+		close(chDone)
+	}()
+
+	chIn <- NewMetric("a", 1, time.Unix(1234567890, 0))
+	chIn <- NewMetric("b", 2, time.Unix(1234567890, 0))
+	time.Sleep(3 * SpillPollInterval)
+
+	close(chIn)
+	<-chDone
+
+	// the paused segment and the ones spilled meanwhile stay on disk
+	restarted := newTestSpillWorker(t, cfg, nil, nil, nil)
+
+	count := 0
+	for _, segment := range restarted.segments {
+		count += segment.count
+	}
+
+	assert.Equal(t, 2, count)
+	assert.Equal(t, w.size, restarted.size)
+}
@@ -0,0 +1,279 @@
+package pirate
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/op/go-logging"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	FramingLength    = "length"
+	FramingBlankLine = "blank_line"
+)
+
+// StreamServer reads a stream of messages per connection. Messages are either prefixed by their length as 4 byte
+// unsigned big endian integer or separated by a blank line.
+type StreamServer struct {
+	name     string
+	label    string
+	listen   func() (net.Listener, error)
+	cfg      *StreamConfig
+	limiter  *IpLimiter
+	limitKey func(conn net.Conn) string
+	logger   *logging.Logger
+	stats    *MonitoringStats
+	chPacket chan<- *Packet
+	listener net.Listener
+	conns    map[net.Conn]struct{}
+	closing  bool
+	wg       sync.WaitGroup
+	mu       sync.Mutex
+}
+
+// NewTcpServer creates a stream server for TCP connections, which are not rate limited.
+func NewTcpServer(cfg *TcpConfig, logger *logging.Logger, stats *MonitoringStats, chPacket chan<- *Packet) *StreamServer {
+	listen := func() (net.Listener, error) {
+		return net.Listen("tcp", cfg.Address)
+	}
+
+	return newStreamServer("tcp", "TCP", listen, &cfg.StreamConfig, nil, nil, logger, stats, chPacket)
+}
+
+func newStreamServer(name string, label string, listen func() (net.Listener, error), cfg *StreamConfig, limiter *IpLimiter, limitKey func(conn net.Conn) string, logger *logging.Logger, stats *MonitoringStats, chPacket chan<- *Packet) *StreamServer {
+	return &StreamServer{
+		name:     name,
+		label:    label,
+		listen:   listen,
+		cfg:      cfg,
+		limiter:  limiter,
+		limitKey: limitKey,
+		logger:   logger,
+		stats:    stats,
+		chPacket: chPacket,
+		conns:    make(map[net.Conn]struct{}),
+	}
+}
+
+// Run accepts connections until Stop was called and all connection handlers are finished.
+func (s *StreamServer) Run() error {
+	listener, err := s.listen()
+	if err != nil {
+		return fmt.Errorf("Unable to start %s server: %s", s.label, err)
+	}
+
+	s.mu.Lock()
+	s.listener = listener
+	closing := s.closing
+	s.mu.Unlock()
+
+	if closing {
+		listener.Close()
+		return nil
+	}
+
+	s.logger.Infof("[%s] Listening on %s with %s framing", s.label, listener.Addr(), s.cfg.Framing)
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if s.isClosing() {
+				break
+			}
+
+			s.logger.Infof("[%s] Failed to accept connection: %s", s.label, err)
+			continue
+		}
+
+		if !s.track(conn) {
+			s.logger.Infof("[%s] Connection limit of %d reached, rejecting %s", s.label, s.cfg.MaxConnections, conn.RemoteAddr())
+			s.stats.IncConnectionsRejected(s.name)
+			conn.Close()
+
+			continue
+		}
+
+		s.stats.IncConnections(s.name)
+		go s.handle(conn)
+	}
+
+	s.wg.Wait()
+	s.logger.Infof("[%s] Server stopped", s.label)
+
+	return nil
+}
+
+// Stop closes the listener and all open connections. Messages which are already read are still processed.
+func (s *StreamServer) Stop() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.closing = true
+	if s.listener != nil {
+		s.listener.Close()
+	}
+
+	for conn := range s.conns {
+		conn.Close()
+	}
+}
+
+func (s *StreamServer) isClosing() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.closing
+}
+
+func (s *StreamServer) track(conn net.Conn) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closing || len(s.conns) >= s.cfg.MaxConnections {
+		return false
+	}
+
+	s.conns[conn] = struct{}{}
+	s.wg.Add(1)
+
+	return true
+}
+
+func (s *StreamServer) untrack(conn net.Conn) {
+	s.mu.Lock()
+	delete(s.conns, conn)
+	s.mu.Unlock()
+
+	conn.Close()
+	s.wg.Done()
+}
+
+func (s *StreamServer) handle(conn net.Conn) {
+	defer s.untrack(conn)
+
+	addr := conn.RemoteAddr().String()
+	ip := net.ParseIP(hostOf(addr))
+	reader := bufio.NewReader(conn)
+	messages, bytesIn := 0, 0
+
+	var key string
+	if s.limiter != nil {
+		key = s.limitKey(conn)
+		addr = key
+	}
+
+	s.logger.Debugf("[%s] Accepted connection from %s", s.label, addr)
+
+	for {
+		conn.SetReadDeadline(time.Now().Add(s.cfg.IdleTimeout))
+
+		payload, err := s.readMessage(reader)
+		if err != nil {
+			switch {
+			case errors.Is(err, io.EOF), s.isClosing():
+			case errors.Is(err, os.ErrDeadlineExceeded):
+				s.logger.Infof("[%s] Connection from %s was idle for %s", s.label, addr, s.cfg.IdleTimeout)
+			default:
+				s.logger.Infof("[%s] Failed to read message from %s: %s", s.label, addr, err)
+			}
+
+			break
+		}
+
+		if len(payload) == 0 {
+			continue
+		}
+
+		messages++
+		bytesIn += len(payload)
+		s.logger.Debugf("[%s] Received %d bytes from %s", s.label, len(payload), addr)
+		s.stats.IncBytesIn(len(payload))
+		s.stats.IncListenerReceived(s.name)
+
+		// check rate limit
+		if s.limiter != nil && !s.limiter.AllowKey(key) {
+			s.logger.Infof("[%s] Rate Limit reached for %s", s.label, key)
+			s.stats.IncListenerDropped(s.name)
+
+			continue
+		}
+
+		packet := NewPacket(payload, ip, time.Now())
+		packet.MessageFormat = s.cfg.MessageFormat
+		packet.LimitKey = key
+
+		// block instead of dropping, the client is slowed down by TCP backpressure
+		s.chPacket <- packet
+	}
+
+	s.logger.Infof("[%s] Connection from %s closed after %d messages with %d bytes", s.label, addr, messages, bytesIn)
+}
+
+func (s *StreamServer) readMessage(reader *bufio.Reader) ([]byte, error) {
+	if s.cfg.Framing == FramingBlankLine {
+		return readBlankLineMessage(reader, s.cfg.MaxMessageSize)
+	}
+
+	return readLengthPrefixedMessage(reader, s.cfg.MaxMessageSize)
+}
+
+// readLengthPrefixedMessage returns an empty message for a zero length prefix, which clients may use as keepalive
+func readLengthPrefixedMessage(reader *bufio.Reader, maxSize int) ([]byte, error) {
+	prefix := make([]byte, 4)
+	if _, err := io.ReadFull(reader, prefix); err != nil {
+		return nil, err
+	}
+
+	size := binary.BigEndian.Uint32(prefix)
+	if int64(size) > int64(maxSize) {
+		return nil, fmt.Errorf("Message size of %d bytes exceeds limit of %d bytes", size, maxSize)
+	}
+
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(reader, payload); err != nil {
+		return nil, err
+	}
+
+	return payload, nil
+}
+
+// readBlankLineMessage returns all lines up to the next blank line. The last message of a stream does not need to be
+// terminated by a blank line. Lines are read in chunks of the reader's buffer size, so that the memory is bounded by
+// the maximum message size even for clients which never send a line break.
+func readBlankLineMessage(reader *bufio.Reader, maxSize int) ([]byte, error) {
+	var payload []byte
+	lineStart := 0
+
+	for {
+		chunk, err := reader.ReadSlice('\n')
+
+		// the terminating blank line is not part of the message
+		if err == nil && len(bytes.TrimSpace(payload[lineStart:])) == 0 && len(bytes.TrimSpace(chunk)) == 0 {
+			return payload[:lineStart], nil
+		}
+
+		if len(payload)+len(chunk) > maxSize {
+			return nil, fmt.Errorf("Message size exceeds limit of %d bytes", maxSize)
+		}
+
+		payload = append(payload, chunk...)
+
+		switch {
+		case err == nil:
+			lineStart = len(payload)
+		case errors.Is(err, bufio.ErrBufferFull):
+			// the line continues in the next chunk
+		case errors.Is(err, io.EOF) && len(payload) > 0:
+			return payload, nil
+		default:
+			return nil, err
+		}
+	}
+}
@@ -0,0 +1,225 @@
+package pirate
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"github.com/op/go-logging"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestLogger() *logging.Logger {
+	logging.SetLevel(logging.ERROR, "test")
+
+	return logging.MustGetLogger("test")
+}
+
+func lengthPrefixed(messages ...string) []byte {
+	var b []byte
+	for _, msg := range messages {
+		b = binary.BigEndian.AppendUint32(b, uint32(len(msg)))
+		b = append(b, msg...)
+	}
+
+	return b
+}
+
+func TestReadLengthPrefixedMessage(t *testing.T) {
+	t.Run("messages and keepalive", func(t *testing.T) {
+		reader := bufio.NewReader(bytes.NewReader(lengthPrefixed("foo", "", "bar")))
+
+		for _, expected := range []string{"foo", "", "bar"} {
+			payload, err := readLengthPrefixedMessage(reader, 10)
+
+			assert.Nil(t, err)
+			assert.Equal(t, expected, string(payload))
+		}
+
+		_, err := readLengthPrefixedMessage(reader, 10)
+		assert.Equal(t, io.EOF, err)
+	})
+
+	t.Run("oversize", func(t *testing.T) {
+		reader := bufio.NewReader(bytes.NewReader(lengthPrefixed("foobar")))
+
+		_, err := readLengthPrefixedMessage(reader, 5)
+
+		assert.ErrorContains(t, err, "exceeds limit of 5 bytes")
+	})
+
+	t.Run("truncated", func(t *testing.T) {
+		reader := bufio.NewReader(bytes.NewReader(lengthPrefixed("foobar")[:7]))
+
+		_, err := readLengthPrefixedMessage(reader, 10)
+
+		assert.Equal(t, io.ErrUnexpectedEOF, err)
+	})
+}
+
+func TestReadBlankLineMessage(t *testing.T) {
+	t.Run("messages and keepalive", func(t *testing.T) {
+		reader := bufio.NewReader(strings.NewReader("p=a;\nfps 1\n\n \r\np=b;\nfps 2\n  \np=c;\nfps 3"))
+
+		for _, expected := range []string{"p=a;\nfps 1\n", "", "p=b;\nfps 2\n", "p=c;\nfps 3"} {
+			payload, err := readBlankLineMessage(reader, 100)
+
+			assert.Nil(t, err)
+			assert.Equal(t, expected, string(payload))
+		}
+
+		_, err := readBlankLineMessage(reader, 100)
+		assert.Equal(t, io.EOF, err)
+	})
+
+	t.Run("oversize", func(t *testing.T) {
+		reader := bufio.NewReader(strings.NewReader("p=a;\nfps 1\nfps 2\n\n"))
+
+		_, err := readBlankLineMessage(reader, 15)
+
+		assert.ErrorContains(t, err, "exceeds limit of 15 bytes")
+	})
+
+	t.Run("exact size", func(t *testing.T) {
+		reader := bufio.NewReader(strings.NewReader("p=a;\nfps 1\n\n"))
+
+		payload, err := readBlankLineMessage(reader, 11)
+
+		assert.Nil(t, err)
+		assert.Equal(t, "p=a;\nfps 1\n", string(payload))
+	})
+
+	t.Run("line without line break", func(t *testing.T) {
+		// the reader must stop at the limit instead of buffering the whole line
+		reader := bufio.NewReaderSize(io.MultiReader(strings.NewReader("p=a;\n"), neverEnding('x')), 16)
+
+		_, err := readBlankLineMessage(reader, 100)
+
+		assert.ErrorContains(t, err, "exceeds limit of 100 bytes")
+	})
+
+	t.Run("oversize at end of stream", func(t *testing.T) {
+		reader := bufio.NewReaderSize(strings.NewReader("p=a;\n"+strings.Repeat("x", 200)), 16)
+
+		_, err := readBlankLineMessage(reader, 100)
+
+		assert.ErrorContains(t, err, "exceeds limit of 100 bytes")
+	})
+
+	t.Run("long lines", func(t *testing.T) {
+		msg := "p=a;\nfps " + strings.Repeat("1", 40) + "\n"
+		reader := bufio.NewReaderSize(strings.NewReader(msg+"\n"), 16)
+
+		payload, err := readBlankLineMessage(reader, 100)
+
+		assert.Nil(t, err)
+		assert.Equal(t, msg, string(payload))
+	})
+}
+
+type neverEnding byte
+
+func (b neverEnding) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = byte(b)
+	}
+
+	return len(p), nil
+}
+
+func TestTcpServer(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+
+	chPacket := make(chan *Packet, 10)
+	cfg := defaultStreamConfig
+	cfg.MaxConnections = 1
+	cfg.MessageFormat = MessageFormatJSON
+
+	stats := NewMonitoringStats()
+	listen := func() (net.Listener, error) { return listener, nil }
+	s := newStreamServer("tcp", "TCP", listen, &cfg, nil, nil, newTestLogger(), stats, chPacket)
+
+	chDone := make(chan error)
+	go func() { chDone <- s.Run() }()
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	assert.Nil(t, err)
+
+	_, err = conn.Write(lengthPrefixed("foo", "", "bar"))
+	assert.Nil(t, err)
+
+	for _, expected := range []string{"foo", "bar"} {
+		select {
+		case packet := <-chPacket:
+			assert.Equal(t, expected, string(packet.Payload))
+			assert.Equal(t, MessageFormatJSON, packet.MessageFormat)
+			assert.True(t, packet.IP.IsLoopback())
+		case <-time.After(time.Second):
+			t.Fatal("Packet was not received")
+		}
+	}
+
+	// the connection limit is reached
+	rejected, err := net.Dial("tcp", listener.Addr().String())
+	assert.Nil(t, err)
+	rejected.SetReadDeadline(time.Now().Add(time.Second))
+	_, err = rejected.Read(make([]byte, 1))
+	assert.Equal(t, io.EOF, err)
+
+	s.Stop()
+	assert.Nil(t, <-chDone)
+
+	conn.Close()
+	rejected.Close()
+
+	counters := stats.Reset()
+	assert.Equal(t, 1, counters["tcp_connections"])
+	assert.Equal(t, 1, counters["tcp_connections_rejected"])
+	assert.Equal(t, 2, counters["tcp_received"])
+}
+
+func TestStreamServerOversizeMessage(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+
+	chPacket := make(chan *Packet, 10)
+	cfg := defaultStreamConfig
+	cfg.Framing = FramingBlankLine
+	cfg.MaxMessageSize = 1024
+
+	listen := func() (net.Listener, error) { return listener, nil }
+	s := newStreamServer("tcp", "TCP", listen, &cfg, nil, nil, newTestLogger(), NewMonitoringStats(), chPacket)
+
+	chDone := make(chan error)
+	go func() { chDone <- s.Run() }()
+	defer func() {
+		s.Stop()
+		<-chDone
+	}()
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	assert.Nil(t, err)
+	defer conn.Close()
+
+	// the server closes the connection once the limit is exceeded, the client may fail to write the rest
+	conn.Write([]byte("p=a;\nfps 1\n\n" + strings.Repeat("x", 64*1024)))
+
+	select {
+	case packet := <-chPacket:
+		assert.Equal(t, "p=a;\nfps 1\n", string(packet.Payload))
+	case <-time.After(time.Second):
+		t.Fatal("Packet was not received")
+	}
+
+	// the connection is closed or reset with unread data, but does not time out
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	_, err = io.Copy(io.Discard, conn)
+	assert.False(t, errors.Is(err, os.ErrDeadlineExceeded), "Connection must be closed by the server")
+}
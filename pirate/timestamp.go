@@ -0,0 +1,154 @@
+package pirate
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+)
+
+const (
+	// PrecisionHeader is a reserved header attribute, which sets the unit of all timestamps without suffix
+	PrecisionHeader = "ts_precision"
+
+	// ClientTimeHeader is a reserved header attribute with the client's current time, which is used to correct the
+	// timestamps of clients with a wrong clock
+	ClientTimeHeader = "now"
+
+	TimestampFloor = "floor"
+	TimestampRound = "round"
+	TimestampNone  = "none"
+)
+
+var precisionSuffixes = []struct {
+	suffix    []byte
+	precision time.Duration
+}{
+	{[]byte("ms"), time.Millisecond},
+	{[]byte("us"), time.Microsecond},
+	{[]byte("ns"), time.Nanosecond},
+	{[]byte("s"), time.Second},
+}
+
+// ParsePrecision parses the unit of a timestamp: s, ms, us or ns
+func ParsePrecision(unit []byte) (time.Duration, error) {
+	for _, p := range precisionSuffixes {
+		if bytes.Equal(unit, p.suffix) {
+			return p.precision, nil
+		}
+	}
+
+	return 0, fmt.Errorf(`invalid precision "%s" (must be "s", "ms", "us" or "ns")`, unit)
+}
+
+// ParseTimestamp parses an integer timestamp. The unit is taken from its suffix, e.g. "1700000000123ms", otherwise
+// from the given precision. Without both, it is detected by the magnitude: values up to 11 digits are seconds, up to
+// 14 digits milliseconds, up to 17 digits microseconds and nanoseconds beyond.
+func ParseTimestamp(ts []byte, precision time.Duration) (time.Time, time.Duration, error) {
+	for _, p := range precisionSuffixes {
+		if bytes.HasSuffix(ts, p.suffix) {
+			ts, precision = ts[:len(ts)-len(p.suffix)], p.precision
+			break
+		}
+	}
+
+	value, err := strconv.ParseInt(string(ts), 10, 64)
+	if err != nil || value < 0 {
+		return time.Time{}, 0, errors.New("timestamp must be int64-compatible")
+	}
+
+	if precision == 0 {
+		switch {
+		case value < 1e11:
+			precision = time.Second
+		case value < 1e14:
+			precision = time.Millisecond
+		case value < 1e17:
+			precision = time.Microsecond
+		default:
+			precision = time.Nanosecond
+		}
+	}
+
+	unitsPerSecond := int64(time.Second / precision)
+	if value/unitsPerSecond > math.MaxInt64/int64(time.Second) {
+		return time.Time{}, 0, errors.New("timestamp is out of range")
+	}
+
+	return time.Unix(value/unitsPerSecond, value%unitsPerSecond*int64(precision)), precision, nil
+}
+
+// SetTime replaces the timestamp of the metric by the whole seconds of t and keeps the fraction and precision
+// separately, so that formats without sub-second timestamps can use the Timestamp as it is
+func (m *Metric) SetTime(t time.Time, precision time.Duration) {
+	m.Timestamp = strconv.AppendInt(nil, t.Unix(), 10)
+	m.Nanos = t.Nanosecond()
+	m.Precision = precision
+}
+
+// fractionalTimestamp returns the timestamp in seconds with as many decimals as the precision of the metric
+func fractionalTimestamp(m *Metric) []byte {
+	if m.Precision == 0 || m.Precision >= time.Second {
+		return m.Timestamp
+	}
+
+	digits := len(strconv.FormatInt(int64(time.Second/m.Precision), 10)) - 1
+	fraction := fmt.Sprintf("%09d", m.Nanos)[:digits]
+
+	return append(append(append([]byte{}, m.Timestamp...), '.'), fraction...)
+}
+
+// parseFractionalTimestamp reverses fractionalTimestamp
+func parseFractionalTimestamp(m *Metric, ts []byte) bool {
+	seconds, fraction, found := bytes.Cut(ts, []byte{'.'})
+	if !found {
+		m.Timestamp = ts
+		return true
+	}
+
+	if len(fraction) == 0 || len(fraction) > 9 {
+		return false
+	}
+
+	nanos, err := strconv.Atoi(string(fraction) + "000000000"[len(fraction):])
+	if err != nil {
+		return false
+	}
+
+	m.Timestamp = seconds
+	m.Nanos = nanos
+	m.Precision = time.Duration(math.Pow10(9 - len(fraction)))
+
+	return true
+}
+
+// graphiteTimestamp rounds the timestamp to whole seconds, unless the rounding is "none"
+func graphiteTimestamp(m *Metric, rounding string) []byte {
+	if m.Nanos == 0 {
+		return m.Timestamp
+	}
+
+	switch rounding {
+	case TimestampNone:
+		return fractionalTimestamp(m)
+	case TimestampRound:
+		if m.Nanos >= int(time.Second/2) {
+			if ts, err := strconv.ParseInt(string(m.Timestamp), 10, 64); err == nil {
+				return strconv.AppendInt(nil, ts+1, 10)
+			}
+		}
+	}
+
+	return m.Timestamp
+}
+
+func validateRounding(rounding string) error {
+	switch rounding {
+	case TimestampFloor, TimestampRound, TimestampNone:
+		return nil
+	}
+
+	return fmt.Errorf(`Unsupported timestamp_rounding (must be "floor", "round" or "none"): %s`, rounding)
+}
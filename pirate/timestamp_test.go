@@ -0,0 +1,81 @@
+package pirate
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestParseTimestamp(t *testing.T) {
+	tests := []struct {
+		ts        string
+		precision time.Duration
+		expected  time.Time
+		unit      time.Duration
+	}{
+		{"1700000000", 0, time.Unix(1700000000, 0), time.Second},
+		{"1700000000123", 0, time.Unix(1700000000, 123000000), time.Millisecond},
+		{"1700000000123456", 0, time.Unix(1700000000, 123456000), time.Microsecond},
+		{"1700000000123456789", 0, time.Unix(1700000000, 123456789), time.Nanosecond},
+		{"1700000000123ms", 0, time.Unix(1700000000, 123000000), time.Millisecond},
+		{"1700000000s", time.Millisecond, time.Unix(1700000000, 0), time.Second},
+		{"1700000000123", time.Millisecond, time.Unix(1700000000, 123000000), time.Millisecond},
+		{"1700000000", time.Microsecond, time.Unix(1700, 0), time.Microsecond},
+	}
+
+	for _, test := range tests {
+		t.Run(test.ts, func(t *testing.T) {
+			ts, unit, err := ParseTimestamp([]byte(test.ts), test.precision)
+
+			assert.Nil(t, err)
+			assert.Equal(t, test.expected, ts)
+			assert.Equal(t, test.unit, unit)
+		})
+	}
+
+	for _, ts := range []string{"", "ms", "17000s00000", "1700000000m", "99999999999999999999"} {
+		t.Run("invalid "+ts, func(t *testing.T) {
+			_, _, err := ParseTimestamp([]byte(ts), 0)
+
+			assert.NotNil(t, err)
+		})
+	}
+}
+
+func TestParsePrecision(t *testing.T) {
+	precision, err := ParsePrecision([]byte("us"))
+
+	assert.Nil(t, err)
+	assert.Equal(t, time.Microsecond, precision)
+
+	_, err = ParsePrecision([]byte("min"))
+
+	assert.NotNil(t, err)
+}
+
+func TestGraphiteTimestamp(t *testing.T) {
+	m := &Metric{}
+	m.SetTime(time.Unix(1700000000, 750000000), time.Millisecond)
+
+	assert.Equal(t, []byte("1700000000"), graphiteTimestamp(m, TimestampFloor))
+	assert.Equal(t, []byte("1700000001"), graphiteTimestamp(m, TimestampRound))
+	assert.Equal(t, []byte("1700000000.750"), graphiteTimestamp(m, TimestampNone))
+
+	t.Run("whole seconds", func(t *testing.T) {
+		m := &Metric{}
+		m.SetTime(time.Unix(1700000000, 0), time.Second)
+
+		assert.Equal(t, []byte("1700000000"), graphiteTimestamp(m, TimestampNone))
+	})
+}
+
+func TestFractionalTimestamp(t *testing.T) {
+	m := &Metric{}
+	m.SetTime(time.Unix(1700000000, 123456000), time.Microsecond)
+
+	parsed := &Metric{}
+	assert.True(t, parseFractionalTimestamp(parsed, fractionalTimestamp(m)))
+	assert.Equal(t, m, parsed)
+
+	assert.False(t, parseFractionalTimestamp(parsed, []byte("1700000000.")))
+}
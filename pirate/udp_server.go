@@ -1,71 +1,211 @@
 package pirate
 
 import (
+	"context"
 	"fmt"
 	"github.com/op/go-logging"
+	"golang.org/x/net/ipv4"
 	"net"
+	"sync"
+	"time"
 )
 
 const (
 	UdpBufferSize = 64 * 1024
 )
 
+type udpSocket struct {
+	id       int
+	conn     *net.UDPConn
+	listener *UdpListenerConfig
+	counters udpSocketCounters
+}
+
 type UdpServer struct {
-	address *net.UDPAddr
-	logger  *logging.Logger
-	stats   *MonitoringStats
-	limiter *IpLimiter
-	chUdp   chan<- []byte
+	cfg       *UdpConfig
+	listeners []*UdpListenerConfig
+	logger    *logging.Logger
+	stats     *MonitoringStats
+	limiter   *IpLimiter
+	chUdp     chan<- *Packet
+	sockets   []*udpSocket
+	closing   bool
+	mu        sync.Mutex
 }
 
-func NewUdpServer(address string, ratelimit *RateLimitConfig, logger *logging.Logger, stats *MonitoringStats, chUdp chan<- []byte) (*UdpServer, error) {
-	parsedAddr, err := net.ResolveUDPAddr("udp", address)
-	if err != nil {
-		return nil, fmt.Errorf("Unable to resolve UDP address %s: %s", address, err)
+// NewUdpServer creates a server with one or more sockets per listener bound to the same address, each read by its own
+// goroutine. Multiple sockets require SO_REUSEPORT, so that the kernel distributes the packets among them. Sockets are
+// numbered in the order of the listeners.
+func NewUdpServer(cfg *UdpConfig, listeners []*UdpListenerConfig, limiter *IpLimiter, logger *logging.Logger, stats *MonitoringStats, chUdp chan<- *Packet) (*UdpServer, error) {
+	for _, listener := range listeners {
+		if _, err := net.ResolveUDPAddr("udp", listener.Address); err != nil {
+			return nil, fmt.Errorf("Unable to resolve UDP address %s: %s", listener.Address, err)
+		}
 	}
 
-	limiter := NewIpLimiter(ratelimit.Amount, ratelimit.Interval)
+	if cfg.UdpSockets > 1 && !reusePortSupported {
+		return nil, fmt.Errorf("Multiple UDP sockets require SO_REUSEPORT, which is not supported on this platform")
+	}
 
-	return &UdpServer{parsedAddr, logger, stats, limiter, chUdp}, nil
+	return &UdpServer{cfg: cfg, listeners: listeners, logger: logger, stats: stats, limiter: limiter, chUdp: chUdp}, nil
 }
 
 func (s *UdpServer) Run() error {
-	conn, err := net.ListenUDP("udp", s.address)
+	sockets, err := s.listen()
 	if err != nil {
-		return fmt.Errorf("Unable to start UDP server on %s: %s", s.address.String(), err)
+		return err
+	}
+
+	s.mu.Lock()
+	s.sockets = sockets
+	closing := s.closing
+	s.mu.Unlock()
+
+	if closing {
+		s.closeSockets()
+		return nil
+	}
+
+	for _, listener := range s.listeners {
+		s.logger.Infof("[UDP] Listening on %s with %d sockets", listener.Address, s.cfg.UdpSockets)
+	}
+
+	wg := &sync.WaitGroup{}
+	for _, socket := range sockets {
+		wg.Add(1)
+		go func(socket *udpSocket) {
+			defer wg.Done()
+			defer socket.conn.Close()
+
+			s.serve(socket)
+		}(socket)
+	}
+	wg.Wait()
+
+	s.logger.Info("[UDP] Server stopped")
+
+	return nil
+}
+
+func (s *UdpServer) Stop() {
+	s.mu.Lock()
+	s.closing = true
+	s.mu.Unlock()
+
+	s.closeSockets()
+}
+
+func (s *UdpServer) closeSockets() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, socket := range s.sockets {
+		socket.conn.Close()
+	}
+}
+
+func (s *UdpServer) isClosing() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.closing
+}
+
+func (s *UdpServer) listen() ([]*udpSocket, error) {
+	lc := net.ListenConfig{}
+	if s.cfg.UdpSockets > 1 {
+		lc.Control = reusePortControl
+	}
+
+	sockets := make([]*udpSocket, 0, len(s.listeners)*s.cfg.UdpSockets)
+	for _, listener := range s.listeners {
+		for i := 0; i < s.cfg.UdpSockets; i++ {
+			conn, err := lc.ListenPacket(context.Background(), "udp", listener.Address)
+			if err != nil {
+				for _, socket := range sockets {
+					socket.conn.Close()
+				}
+
+				return nil, fmt.Errorf("Unable to start UDP server on %s: %s", listener.Address, err)
+			}
+
+			udpConn := conn.(*net.UDPConn)
+			if s.cfg.UdpReceiveBuffer > 0 {
+				if err := udpConn.SetReadBuffer(s.cfg.UdpReceiveBuffer); err != nil {
+					s.logger.Warningf("[UDP] Failed to set receive buffer of %d bytes: %s", s.cfg.UdpReceiveBuffer, err)
+				}
+			}
+
+			id := len(sockets)
+			sockets = append(sockets, &udpSocket{id: id, conn: udpConn, listener: listener, counters: newUdpSocketCounters(id)})
+		}
+	}
+
+	return sockets, nil
+}
+
+// serve reads up to udp_batch_size packets at once, which uses a single recvmmsg call on Linux
+func (s *UdpServer) serve(socket *udpSocket) {
+	pc := ipv4.NewPacketConn(socket.conn)
+
+	batch := make([]ipv4.Message, s.cfg.UdpBatchSize)
+	for i := range batch {
+		batch[i].Buffers = [][]byte{make([]byte, UdpBufferSize)}
 	}
-	defer conn.Close()
 
-	buf := make([]byte, UdpBufferSize)
 	for {
-		// accept packet
-		n, addr, err := conn.ReadFromUDP(buf)
+		// accept packets
+		n, err := pc.ReadBatch(batch, 0)
+		now := time.Now()
 		if err != nil {
+			if s.isClosing() {
+				return
+			}
+
 			s.logger.Infof("[UDP] Failed to read packet: %s", err)
 			continue
 		}
 
-		s.logger.Debugf("[UDP] Received %d bytes", n)
-		s.stats.IncBytesIn(n)
-		s.stats.IncUdpReceived()
-
-		// check rate limit
-		if !s.limiter.Allow(addr.IP) {
-			s.logger.Infof("[UDP] Rate Limit reached for address: %s", addr.IP.String())
-			s.stats.IncUdpDropped()
+		for _, msg := range batch[:n] {
+			addr, ok := msg.Addr.(*net.UDPAddr)
+			if !ok {
+				continue
+			}
 
-			continue
+			s.handle(socket, msg.Buffers[0][:msg.N], addr.IP, now)
 		}
+	}
+}
 
-		// forward packet
-		packet := make([]byte, n)
-		copy(packet, buf)
+func (s *UdpServer) handle(socket *udpSocket, buf []byte, ip net.IP, receivedAt time.Time) {
+	s.logger.Debugf("[UDP] Received %d bytes on socket %d", len(buf), socket.id)
+	s.stats.IncBytesIn(len(buf))
+	s.stats.IncUdpReceived()
+	s.stats.IncUdpSocketReceived(socket.counters)
 
-		select {
-		case s.chUdp <- packet:
-		default:
-			s.logger.Debug("[UDP] Buffer is full, packet got dropped")
-			s.stats.IncUdpDropped()
-		}
+	// check rate limit
+	if !s.limiter.Allow(ip) {
+		s.logger.Infof("[UDP] Rate Limit reached for address: %s", ip.String())
+		s.stats.IncUdpDropped()
+		s.stats.IncUdpSocketDropped(socket.counters)
+
+		return
+	}
+
+	// forward packet
+	payload := getPacketBuffer(len(buf))
+	copy(payload, buf)
+
+	packet := NewPacket(payload, ip, receivedAt)
+	packet.Listener = socket.listener
+	packet.MessageFormat = socket.listener.MessageFormat
+
+	select {
+	case s.chUdp <- packet:
+	default:
+		s.logger.Debug("[UDP] Buffer is full, packet got dropped")
+		releasePacketBuffer(payload)
+		s.stats.IncUdpDropped()
+		s.stats.IncUdpSocketDropped(socket.counters)
 	}
 }
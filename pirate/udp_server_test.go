@@ -0,0 +1,95 @@
+package pirate
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestUdpServerSockets(t *testing.T) {
+	if !reusePortSupported {
+		t.Skip("SO_REUSEPORT is not supported on this platform")
+	}
+
+	// all sockets are bound to the same port, which is free as long as no other test takes it meanwhile
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	address := conn.LocalAddr().String()
+	conn.Close()
+
+	const sockets, clients, packetsPerClient, buffered = 4, 20, 10, 150
+	cfg := &UdpConfig{UdpSockets: sockets, UdpBatchSize: 8}
+	listeners := []*UdpListenerConfig{{Address: address, MessageFormat: MessageFormatLine}}
+	chPacket := make(chan *Packet, buffered)
+	stats := NewMonitoringStats()
+
+	s, err := NewUdpServer(cfg, listeners, NewIpLimiter(1000, time.Minute), newTestLogger(), stats, chPacket)
+	assert.Nil(t, err)
+
+	chDone := make(chan error)
+	go func() { chDone <- s.Run() }()
+
+	counters := make(map[string]int)
+	collect := func() {
+		for key, value := range stats.Reset() {
+			counters[key] += value
+		}
+	}
+
+	// the sockets are ready, as soon as a probe was received
+	probe, err := net.Dial("udp", address)
+	assert.Nil(t, err)
+	defer probe.Close()
+
+	for i := 0; i < 100 && counters["udp_received"] == 0; i++ {
+		// writes fail with "connection refused" until the sockets are bound
+		probe.Write([]byte("project=p;\n"))
+		time.Sleep(10 * time.Millisecond)
+		collect()
+	}
+	assert.Positive(t, counters["udp_received"], "Server did not start")
+	for len(chPacket) > 0 {
+		<-chPacket
+	}
+	counters = make(map[string]int)
+
+	// the kernel distributes the packets among the sockets by the address of the client
+	for c := 0; c < clients; c++ {
+		client, err := net.Dial("udp", address)
+		assert.Nil(t, err)
+
+		for i := 0; i < packetsPerClient; i++ {
+			_, err = client.Write([]byte("project=p;\nfps 1 1234567890\n"))
+			assert.Nil(t, err)
+		}
+		client.Close()
+	}
+
+	const packets = clients * packetsPerClient
+	for i := 0; i < 100 && counters["udp_received"] < packets; i++ {
+		time.Sleep(10 * time.Millisecond)
+		collect()
+	}
+
+	s.Stop()
+	assert.Nil(t, <-chDone)
+
+	assert.Equal(t, packets, counters["udp_received"])
+	assert.Equal(t, packets-buffered, counters["udp_dropped"], "Packets must be dropped once the buffer is full")
+
+	received, dropped, used := 0, 0, 0
+	for socket := 0; socket < sockets; socket++ {
+		received += counters[fmt.Sprintf("udp_socket_%d_received", socket)]
+		dropped += counters[fmt.Sprintf("udp_socket_%d_dropped", socket)]
+		if counters[fmt.Sprintf("udp_socket_%d_received", socket)] > 0 {
+			used++
+		}
+	}
+
+	assert.Equal(t, packets, received)
+	assert.Equal(t, packets-buffered, dropped)
+	assert.Greater(t, used, 1, "Packets must be distributed among the sockets")
+	assert.NotContains(t, counters, fmt.Sprintf("udp_socket_%d_received", sockets))
+}
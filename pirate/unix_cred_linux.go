@@ -0,0 +1,69 @@
+package pirate
+
+import (
+	"fmt"
+	"net"
+	"syscall"
+)
+
+var peerCredentialsOobSize = syscall.CmsgSpace(syscall.SizeofUcred)
+
+// enablePeerCredentials makes the kernel attach the credentials of the sender to every datagram
+func enablePeerCredentials(conn *net.UnixConn) error {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return err
+	}
+
+	var sockErr error
+	err = raw.Control(func(fd uintptr) {
+		sockErr = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_PASSCRED, 1)
+	})
+	if err != nil {
+		return err
+	}
+
+	return sockErr
+}
+
+func datagramPeerKey(oob []byte) string {
+	msgs, err := syscall.ParseSocketControlMessage(oob)
+	if err != nil {
+		return unixFallbackKey
+	}
+
+	for _, msg := range msgs {
+		if cred, err := syscall.ParseUnixCredentials(&msg); err == nil {
+			return credentialKey(cred)
+		}
+	}
+
+	return unixFallbackKey
+}
+
+func streamPeerKey(conn net.Conn) string {
+	unixConn, ok := conn.(*net.UnixConn)
+	if !ok {
+		return unixFallbackKey
+	}
+
+	raw, err := unixConn.SyscallConn()
+	if err != nil {
+		return unixFallbackKey
+	}
+
+	var cred *syscall.Ucred
+	var credErr error
+	err = raw.Control(func(fd uintptr) {
+		cred, credErr = syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+	})
+	if err != nil || credErr != nil {
+		return unixFallbackKey
+	}
+
+	return credentialKey(cred)
+}
+
+func credentialKey(cred *syscall.Ucred) string {
+	return fmt.Sprintf("uid=%d,pid=%d", cred.Uid, cred.Pid)
+}
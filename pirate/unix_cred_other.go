@@ -0,0 +1,22 @@
+//go:build !linux
+
+package pirate
+
+import (
+	"errors"
+	"net"
+)
+
+var peerCredentialsOobSize = 0
+
+func enablePeerCredentials(conn *net.UnixConn) error {
+	return errors.New("not supported on this platform")
+}
+
+func datagramPeerKey(oob []byte) string {
+	return unixFallbackKey
+}
+
+func streamPeerKey(conn net.Conn) string {
+	return unixFallbackKey
+}
@@ -0,0 +1,220 @@
+package pirate
+
+import (
+	"fmt"
+	"github.com/op/go-logging"
+	"net"
+	"os"
+	"os/user"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// unixFallbackKey is used for rate limiting if the credentials of the peer are unknown
+const unixFallbackKey = "unix"
+
+type UnixgramServer struct {
+	cfg     *UnixConfig
+	logger  *logging.Logger
+	stats   *MonitoringStats
+	limiter *IpLimiter
+	chUdp   chan<- *Packet
+	conn    *net.UnixConn
+	closing bool
+	mu      sync.Mutex
+}
+
+// NewUnixgramServer creates a server for datagram unix sockets, which behaves like the UDP server. Clients are rate
+// limited by their uid and pid instead of the IP address.
+func NewUnixgramServer(cfg *UnixConfig, limiter *IpLimiter, logger *logging.Logger, stats *MonitoringStats, chUdp chan<- *Packet) *UnixgramServer {
+	return &UnixgramServer{cfg: cfg, logger: logger, stats: stats, limiter: limiter, chUdp: chUdp}
+}
+
+func (s *UnixgramServer) Run() error {
+	if err := removeStaleSocket(s.cfg.DatagramPath); err != nil {
+		return err
+	}
+
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: s.cfg.DatagramPath, Net: "unixgram"})
+	if err != nil {
+		return fmt.Errorf("Unable to start unix datagram server on %s: %s", s.cfg.DatagramPath, err)
+	}
+	defer os.Remove(s.cfg.DatagramPath)
+	defer conn.Close()
+
+	if err := applySocketPermissions(s.cfg.DatagramPath, s.cfg); err != nil {
+		return err
+	}
+
+	if err := enablePeerCredentials(conn); err != nil {
+		s.logger.Warningf("[Unixgram] Peer credentials are not available, rate limit is shared by all clients: %s", err)
+	}
+
+	s.mu.Lock()
+	s.conn = conn
+	closing := s.closing
+	s.mu.Unlock()
+
+	if closing {
+		return nil
+	}
+
+	s.logger.Infof("[Unixgram] Listening on %s", s.cfg.DatagramPath)
+
+	buf := make([]byte, UdpBufferSize)
+	oob := make([]byte, peerCredentialsOobSize)
+	for {
+		// accept packet
+		n, oobn, _, _, err := conn.ReadMsgUnix(buf, oob)
+		now := time.Now()
+		if err != nil {
+			if s.isClosing() {
+				s.logger.Info("[Unixgram] Server stopped")
+				return nil
+			}
+
+			s.logger.Infof("[Unixgram] Failed to read packet: %s", err)
+			continue
+		}
+
+		key := datagramPeerKey(oob[:oobn])
+
+		s.logger.Debugf("[Unixgram] Received %d bytes from %s", n, key)
+		s.stats.IncBytesIn(n)
+		s.stats.IncListenerReceived("unixgram")
+
+		// check rate limit
+		if !s.limiter.AllowKey(key) {
+			s.logger.Infof("[Unixgram] Rate Limit reached for %s", key)
+			s.stats.IncListenerDropped("unixgram")
+
+			continue
+		}
+
+		// forward packet
+		payload := getPacketBuffer(n)
+		copy(payload, buf)
+
+		packet := NewPacket(payload, nil, now)
+		packet.MessageFormat = s.cfg.MessageFormat
+		packet.LimitKey = key
+
+		select {
+		case s.chUdp <- packet:
+		default:
+			s.logger.Debug("[Unixgram] Buffer is full, packet got dropped")
+			releasePacketBuffer(payload)
+			s.stats.IncListenerDropped("unixgram")
+		}
+	}
+}
+
+func (s *UnixgramServer) Stop() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.closing = true
+	if s.conn != nil {
+		s.conn.Close()
+	}
+}
+
+func (s *UnixgramServer) isClosing() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.closing
+}
+
+// NewUnixStreamServer creates a stream server for unix sockets. Clients are rate limited by their uid and pid.
+func NewUnixStreamServer(cfg *UnixConfig, limiter *IpLimiter, logger *logging.Logger, stats *MonitoringStats, chPacket chan<- *Packet) *StreamServer {
+	listen := func() (net.Listener, error) {
+		if err := removeStaleSocket(cfg.StreamPath); err != nil {
+			return nil, err
+		}
+
+		// the socket file is removed by the listener when it gets closed
+		listener, err := net.ListenUnix("unix", &net.UnixAddr{Name: cfg.StreamPath, Net: "unix"})
+		if err != nil {
+			return nil, err
+		}
+
+		if err := applySocketPermissions(cfg.StreamPath, cfg); err != nil {
+			listener.Close()
+			return nil, err
+		}
+
+		return listener, nil
+	}
+
+	return newStreamServer("unix", "Unix", listen, &cfg.StreamConfig, limiter, streamPeerKey, logger, stats, chPacket)
+}
+
+// removeStaleSocket removes the socket of a previous process, but no other files
+func removeStaleSocket(path string) error {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return nil
+	}
+
+	if info.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("Unable to listen on %s: file exists and is no socket", path)
+	}
+
+	return os.Remove(path)
+}
+
+func applySocketPermissions(path string, cfg *UnixConfig) error {
+	if err := os.Chmod(path, cfg.Mode); err != nil {
+		return fmt.Errorf("Unable to change mode of %s: %s", path, err)
+	}
+
+	if cfg.Owner == "" && cfg.Group == "" {
+		return nil
+	}
+
+	uid, gid, err := lookupOwnership(cfg.Owner, cfg.Group)
+	if err != nil {
+		return err
+	}
+
+	if err := os.Chown(path, uid, gid); err != nil {
+		return fmt.Errorf("Unable to change ownership of %s: %s", path, err)
+	}
+
+	return nil
+}
+
+// lookupOwnership resolves user and group names or numeric ids, -1 keeps the current value
+func lookupOwnership(owner string, group string) (int, int, error) {
+	uid, gid := -1, -1
+
+	if owner != "" {
+		id := owner
+		if u, err := user.Lookup(owner); err == nil {
+			id = u.Uid
+		}
+
+		parsed, err := strconv.Atoi(id)
+		if err != nil {
+			return 0, 0, fmt.Errorf("Unknown socket owner %s", owner)
+		}
+		uid = parsed
+	}
+
+	if group != "" {
+		id := group
+		if g, err := user.LookupGroup(group); err == nil {
+			id = g.Gid
+		}
+
+		parsed, err := strconv.Atoi(id)
+		if err != nil {
+			return 0, 0, fmt.Errorf("Unknown socket group %s", group)
+		}
+		gid = parsed
+	}
+
+	return uid, gid, nil
+}
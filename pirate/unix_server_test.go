@@ -0,0 +1,137 @@
+package pirate
+
+import (
+	"github.com/stretchr/testify/assert"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// waitForSocket waits until the socket exists and its permissions were applied
+func waitForSocket(t *testing.T, path string, mode os.FileMode) {
+	for i := 0; i < 100; i++ {
+		if info, err := os.Stat(path); err == nil && info.Mode()&os.ModeSocket != 0 && info.Mode().Perm() == mode {
+			return
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	t.Fatalf("Socket %s was not created", path)
+}
+
+func newTestUnixConfig(t *testing.T) *UnixConfig {
+	dir := t.TempDir()
+
+	cfg := *DefaultConfig.Unix
+	cfg.DatagramPath = filepath.Join(dir, "pirate.dgram")
+	cfg.StreamPath = filepath.Join(dir, "pirate.sock")
+	cfg.Mode = 0600
+	cfg.MessageFormat = MessageFormatLine
+
+	return &cfg
+}
+
+func TestUnixgramServer(t *testing.T) {
+	cfg := newTestUnixConfig(t)
+	chPacket := make(chan *Packet, 10)
+	stats := NewMonitoringStats()
+
+	s := NewUnixgramServer(cfg, NewIpLimiter(1, time.Minute), newTestLogger(), stats, chPacket)
+
+	chDone := make(chan error)
+	go func() { chDone <- s.Run() }()
+	waitForSocket(t, cfg.DatagramPath, 0600)
+
+	conn, err := net.Dial("unixgram", cfg.DatagramPath)
+	assert.Nil(t, err)
+	defer conn.Close()
+
+	_, err = conn.Write([]byte("project=p;\nfps 1 1234567890\n"))
+	assert.Nil(t, err)
+
+	select {
+	case packet := <-chPacket:
+		assert.Equal(t, "project=p;\nfps 1 1234567890\n", string(packet.Payload))
+		assert.Equal(t, MessageFormatLine, packet.MessageFormat)
+		assert.Nil(t, packet.IP)
+	case <-time.After(time.Second):
+		t.Fatal("Packet was not received")
+	}
+
+	// the rate limit of the peer is reached
+	_, err = conn.Write([]byte("project=p;\nfps 2 1234567890\n"))
+	assert.Nil(t, err)
+
+	counters := make(map[string]int)
+	for i := 0; i < 100 && counters["unixgram_dropped"] == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+		for key, value := range stats.Reset() {
+			counters[key] += value
+		}
+	}
+
+	s.Stop()
+	assert.Nil(t, <-chDone)
+
+	_, err = os.Stat(cfg.DatagramPath)
+	assert.True(t, os.IsNotExist(err), "Socket must be removed")
+
+	assert.Len(t, chPacket, 0)
+	assert.Equal(t, 2, counters["unixgram_received"])
+	assert.Equal(t, 1, counters["unixgram_dropped"])
+}
+
+func TestUnixStreamServer(t *testing.T) {
+	cfg := newTestUnixConfig(t)
+	chPacket := make(chan *Packet, 10)
+
+	s := NewUnixStreamServer(cfg, NewIpLimiter(10, time.Minute), newTestLogger(), NewMonitoringStats(), chPacket)
+
+	chDone := make(chan error)
+	go func() { chDone <- s.Run() }()
+	waitForSocket(t, cfg.StreamPath, 0600)
+
+	conn, err := net.Dial("unix", cfg.StreamPath)
+	assert.Nil(t, err)
+	defer conn.Close()
+
+	_, err = conn.Write(lengthPrefixed("project=p;\nfps 1 1234567890\n"))
+	assert.Nil(t, err)
+
+	select {
+	case packet := <-chPacket:
+		assert.Equal(t, "project=p;\nfps 1 1234567890\n", string(packet.Payload))
+		assert.Equal(t, MessageFormatLine, packet.MessageFormat)
+	case <-time.After(time.Second):
+		t.Fatal("Packet was not received")
+	}
+
+	s.Stop()
+	assert.Nil(t, <-chDone)
+
+	_, err = os.Stat(cfg.StreamPath)
+	assert.True(t, os.IsNotExist(err), "Socket must be removed")
+}
+
+func TestRemoveStaleSocket(t *testing.T) {
+	dir := t.TempDir()
+
+	// socket of a previous process
+	path := filepath.Join(dir, "stale.sock")
+	stale, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	assert.Nil(t, err)
+	stale.Close()
+
+	assert.Nil(t, removeStaleSocket(path))
+	_, err = os.Stat(path)
+	assert.True(t, os.IsNotExist(err))
+
+	path = filepath.Join(dir, "file.sock")
+	assert.Nil(t, os.WriteFile(path, nil, 0600))
+
+	assert.ErrorContains(t, removeStaleSocket(path), "is no socket")
+	assert.Nil(t, removeStaleSocket(filepath.Join(dir, "missing.sock")))
+}
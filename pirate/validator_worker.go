@@ -1,24 +1,39 @@
 package pirate
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
 	"github.com/op/go-logging"
+	"math"
 	"strconv"
 	"sync"
 	"time"
 )
 
+var (
+	errProjectNotAllowed     = errors.New("Project is not allowed on this listener")
+	errCompressionNotAllowed = errors.New("Compression is not allowed for this project")
+	errDictionaryNotAllowed  = errors.New("Zstd dictionary does not belong to this project")
+)
+
 type validatorWorker struct {
-	cfg    *Config
-	logger *logging.Logger
-	stats  *MonitoringStats
-	chIn   <-chan *Message
-	chOut  chan<- *Message
+	cfg      *SharedConfig
+	logger   *logging.Logger
+	stats    *MonitoringStats
+	chIn     <-chan *Message
+	chOut    chan<- *Message
+	chMirror chan<- *Message
+}
+
+func NewValidatorWorker(cfg *SharedConfig, logger *logging.Logger, stats *MonitoringStats, chIn <-chan *Message, chOut chan<- *Message) *validatorWorker {
+	return &validatorWorker{cfg: cfg, logger: logger, stats: stats, chIn: chIn, chOut: chOut}
 }
 
-func NewValidatorWorker(cfg *Config, logger *logging.Logger, stats *MonitoringStats, chIn <-chan *Message, chOut chan<- *Message) *validatorWorker {
-	return &validatorWorker{cfg, logger, stats, chIn, chOut}
+// MirrorTo additionally sends all valid messages to the given channel, e.g. for the prometheus exporter.
+// Mirrored messages must not be modified and are dropped, if the channel is full.
+func (w *validatorWorker) MirrorTo(ch chan<- *Message) {
+	w.chMirror = ch
 }
 
 func (w *validatorWorker) Run(concurrency int) {
@@ -43,13 +58,32 @@ func (w *validatorWorker) run(wg *sync.WaitGroup) {
 		if err := w.validateMsg(msg); err != nil {
 			w.logger.Noticef("[Validator] Validation failed: %s", err)
 			w.stats.IncMsgDropped()
+			if errors.Is(err, errProjectNotAllowed) {
+				w.stats.IncMsgDroppedProjectNotAllowed()
+			}
+			if errors.Is(err, errCompressionNotAllowed) {
+				w.stats.IncMsgDroppedCompressionNotAllowed()
+			}
+			if errors.Is(err, errDictionaryNotAllowed) {
+				w.stats.IncMsgDroppedDictionaryNotAllowed()
+			}
 			w.stats.IncMetricsDropped(metricsBefore)
+			msg.Packet.Done(err)
 
 			continue
 		}
 
 		w.logger.Debugf("[Validator] Validation succeeded with %d of %d metrics", len(msg.Metrics), metricsBefore)
 		w.stats.IncMetricsDropped(metricsBefore - len(msg.Metrics))
+		msg.Packet.Done(nil)
+
+		if w.chMirror != nil {
+			select {
+			case w.chMirror <- msg:
+			default:
+				w.stats.IncMirrorDropped()
+			}
+		}
 
 		w.chOut <- msg
 	}
@@ -65,11 +99,57 @@ func (w *validatorWorker) validateMsg(msg *Message) error {
 	}
 
 	// check, if target project is configured
-	projectCfg, exists := w.cfg.Projects[string(pid)]
+	projectCfg, exists := w.cfg.Load().Projects[string(pid)]
 	if !exists {
 		return fmt.Errorf(`Unknown project ID "%s"`, pid)
 	}
 
+	// check, if the project may use the listener the message was received on
+	if msg.Packet != nil && msg.Packet.Listener != nil && !msg.Packet.Listener.Allows(string(pid)) {
+		return fmt.Errorf(`%w: "%s" on %s`, errProjectNotAllowed, pid, msg.Packet.Listener.Address)
+	}
+
+	// check, if the project accepts the compression of the message
+	if msg.Packet != nil && projectCfg.CompressionSet != nil && !projectCfg.CompressionSet[msg.Packet.Compression] {
+		return fmt.Errorf(`%w: %s in "%s"`, errCompressionNotAllowed, msg.Packet.Compression, pid)
+	}
+
+	// check, if the dictionary is one of the project's dictionaries
+	if msg.Packet != nil && msg.Packet.DictionaryID != 0 && !projectCfg.ZstdDictionaryIDs[msg.Packet.DictionaryID] {
+		return fmt.Errorf(`%w: %d in "%s"`, errDictionaryNotAllowed, msg.Packet.DictionaryID, pid)
+	}
+
+	// the precision header applies to all timestamps without unit suffix
+	var precision time.Duration
+	if unit, exists := msg.Header[PrecisionHeader]; exists {
+		var err error
+		if precision, err = ParsePrecision(unit); err != nil {
+			return fmt.Errorf(`Invalid header "%s": %s`, PrecisionHeader, err)
+		}
+
+		// reserved headers are no attributes, e.g. for influx tags
+		delete(msg.Header, PrecisionHeader)
+	}
+
+	receivedAt := time.Now()
+	if msg.Packet != nil && !msg.Packet.ReceivedAt.IsZero() {
+		receivedAt = msg.Packet.ReceivedAt
+	}
+
+	// the clock skew of the client is the difference of its current time to the receive time, rounded to the
+	// precision of the client's time, so that timestamps in seconds are shifted by whole seconds
+	var skew time.Duration
+	now, hasClientTime := msg.Header[ClientTimeHeader]
+	if hasClientTime {
+		clientTime, clientPrecision, err := ParseTimestamp(now, precision)
+		if err != nil {
+			return fmt.Errorf(`Invalid header "%s": %s`, ClientTimeHeader, err)
+		}
+
+		skew = receivedAt.Sub(clientTime).Round(clientPrecision)
+		delete(msg.Header, ClientTimeHeader)
+	}
+
 	// validate headers against regex
 	for key, value := range msg.Header {
 		// project is already valid by its existence in config
@@ -94,7 +174,7 @@ func (w *validatorWorker) validateMsg(msg *Message) error {
 	// validate metrics
 	validIdx := 0
 	for _, metric := range msg.Metrics {
-		if err := w.validateMetric(projectCfg, metric); err != nil {
+		if err := w.validateMetric(projectCfg, metric, precision, receivedAt, skew); err != nil {
 			w.logger.Infof("[Validator] Validation failed for %s.%s: %s", pid, metric.Name, err)
 			continue
 		}
@@ -109,10 +189,17 @@ func (w *validatorWorker) validateMsg(msg *Message) error {
 		return errors.New("No valid metrics found")
 	}
 
+	// only the skew of valid messages is reported, so that garbage does not distort it
+	if hasClientTime {
+		w.stats.ObserveClockSkew(string(pid), skew)
+	}
+
+	msg.Project = projectCfg
+
 	return nil
 }
 
-func (w *validatorWorker) validateMetric(cfg *ProjectConfig, metric *Metric) error {
+func (w *validatorWorker) validateMetric(cfg *ProjectConfig, metric *Metric, precision time.Duration, receivedAt time.Time, skew time.Duration) error {
 	// check, if metrics key is configured
 	key := string(metric.Name)
 	metricCfg, exists := cfg.Metrics[key]
@@ -120,13 +207,24 @@ func (w *validatorWorker) validateMetric(cfg *ProjectConfig, metric *Metric) err
 		return fmt.Errorf(`unknown metric key "%s"`, key)
 	}
 
-	// validate timestamp
-	ts, err := strconv.ParseInt(string(metric.Timestamp), 10, 64)
+	// metrics without timestamp get the time the packet was received
+	if len(metric.Timestamp) == 0 {
+		if !cfg.AllowMissingTimestamps {
+			return errors.New("missing timestamp")
+		}
+
+		metric.SetTime(receivedAt.Truncate(time.Millisecond), time.Millisecond)
+
+		return validateValue(metricCfg, metric)
+	}
+
+	// validate timestamp after correcting the client's clock skew
+	metricTime, precision, err := ParseTimestamp(metric.Timestamp, precision)
 	if err != nil {
-		return errors.New("timestamp must be int64-compatible")
+		return err
 	}
+	metricTime = metricTime.Add(skew)
 
-	metricTime := time.Unix(ts, 0)
 	if time.Now().Add(10 * time.Second).Truncate(time.Second).Before(metricTime) { // TODO: make max future time configurable
 		return fmt.Errorf("future timestamp (%s ahead)", time.Until(metricTime))
 	}
@@ -135,12 +233,38 @@ func (w *validatorWorker) validateMetric(cfg *ProjectConfig, metric *Metric) err
 		return fmt.Errorf("timestamp too old (%s behind)", time.Until(metricTime.Truncate(time.Second)))
 	}
 
-	// validate value
+	metric.SetTime(metricTime, precision)
+
+	return validateValue(metricCfg, metric)
+}
+
+func validateValue(metricCfg *MetricConfig, metric *Metric) error {
 	value, err := strconv.ParseFloat(string(metric.Value), 64)
-	if err != nil {
+	if err != nil && isSignedNaN(metric.Value) {
+		// the parser accepts a sign for NaN as well, but strconv and the targets only know it without
+		value = math.NaN()
+		metric.Value = []byte("NaN")
+	} else if err != nil {
 		return errors.New("value must be float64-compatible")
 	}
 
+	// NaN and infinity are not checked against the boundaries, if they are allowed at all
+	if math.IsNaN(value) {
+		if !metricCfg.AllowNaN {
+			return errors.New("value is NaN")
+		}
+
+		return nil
+	}
+
+	if math.IsInf(value, 0) {
+		if !metricCfg.AllowInf {
+			return errors.New("value is infinite")
+		}
+
+		return nil
+	}
+
 	if value < metricCfg.Min {
 		return errors.New("value lower than configured minimum")
 	}
@@ -151,3 +275,7 @@ func (w *validatorWorker) validateMetric(cfg *ProjectConfig, metric *Metric) err
 
 	return nil
 }
+
+func isSignedNaN(value []byte) bool {
+	return len(value) == 4 && (value[0] == '+' || value[0] == '-') && bytes.EqualFold(value[1:], []byte("NaN"))
+}
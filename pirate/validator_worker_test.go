@@ -0,0 +1,281 @@
+package pirate
+
+import (
+	"github.com/stretchr/testify/assert"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func newTestValidator(t *testing.T) *validatorWorker {
+	cfg, err := loadTestConfig(t, `
+projects:
+  p:
+    graphite_path: p.{metric.name}
+    attributes:
+      platform: ^(ios|android)$
+    metrics:
+      fps: {min: 0, max: 100}
+`)
+	assert.Nil(t, err)
+
+	return NewValidatorWorker(NewSharedConfig(cfg), newTestLogger(), NewMonitoringStats(), nil, nil)
+}
+
+// newSkewTestMessage creates a message received at receivedAt with the client time now and a single metric
+func newSkewTestMessage(receivedAt time.Time, now string, ts string) *Message {
+	return &Message{
+		Header:  map[string][]byte{"project": []byte("p"), ClientTimeHeader: []byte(now)},
+		Metrics: []*Metric{{Name: []byte("fps"), Value: []byte("30"), Timestamp: []byte(ts)}},
+		Packet:  &Packet{ReceivedAt: receivedAt},
+	}
+}
+
+func unixString(t time.Time) string {
+	return strconv.FormatInt(t.Unix(), 10)
+}
+
+func TestValidatorClockSkew(t *testing.T) {
+	receivedAt := time.Now().Truncate(time.Second)
+
+	t.Run("behind", func(t *testing.T) {
+		w := newTestValidator(t)
+		client := receivedAt.Add(-90 * time.Second)
+
+		msg := newSkewTestMessage(receivedAt, unixString(client), unixString(client.Add(-time.Minute)))
+		assert.Nil(t, w.validateMsg(msg))
+
+		assert.Equal(t, unixString(receivedAt.Add(-time.Minute)), string(msg.Metrics[0].Timestamp))
+		assert.NotContains(t, msg.Header, ClientTimeHeader)
+
+		counters := w.stats.Reset()
+		assert.Equal(t, 1, counters["clock_skew_p_messages"])
+		assert.Equal(t, 1, counters["clock_skew_p_behind"])
+		assert.Equal(t, 0, counters["clock_skew_p_ahead"])
+		assert.Equal(t, 90, counters["clock_skew_p_seconds_sum"])
+		assert.Equal(t, 90, counters["clock_skew_p_seconds_max"])
+	})
+
+	t.Run("ahead", func(t *testing.T) {
+		w := newTestValidator(t)
+		client := receivedAt.Add(time.Hour)
+
+		msg := newSkewTestMessage(receivedAt, unixString(client), unixString(client))
+		assert.Nil(t, w.validateMsg(msg))
+
+		assert.Equal(t, unixString(receivedAt), string(msg.Metrics[0].Timestamp))
+
+		counters := w.stats.Reset()
+		assert.Equal(t, 1, counters["clock_skew_p_ahead"])
+		assert.Equal(t, 3600, counters["clock_skew_p_seconds_max"])
+	})
+
+	t.Run("rounded to the precision of the client time", func(t *testing.T) {
+		w := newTestValidator(t)
+		receivedAt := receivedAt.Add(700 * time.Millisecond)
+
+		// 60.7s behind in seconds are rounded to 61s, so that the timestamps are shifted by whole seconds
+		msg := newSkewTestMessage(receivedAt, unixString(receivedAt.Add(-time.Minute)), unixString(receivedAt.Add(-time.Minute)))
+		assert.Nil(t, w.validateMsg(msg))
+
+		assert.Equal(t, unixString(receivedAt.Add(time.Second)), string(msg.Metrics[0].Timestamp))
+		assert.Equal(t, 0, msg.Metrics[0].Nanos)
+
+		// milliseconds keep the skew exact
+		client := strconv.FormatInt(receivedAt.Add(-time.Minute).UnixMilli(), 10) + "ms"
+		msg = newSkewTestMessage(receivedAt, client, unixString(receivedAt.Add(-time.Minute)))
+		assert.Nil(t, w.validateMsg(msg))
+
+		assert.Equal(t, unixString(receivedAt), string(msg.Metrics[0].Timestamp))
+		assert.Equal(t, 0, msg.Metrics[0].Nanos)
+	})
+
+	t.Run("shifted before the timestamp checks", func(t *testing.T) {
+		w := newTestValidator(t)
+
+		// timestamps, which are in the future or too old by the server's clock, are valid by the client's clock
+		ahead := receivedAt.Add(2 * time.Hour)
+		assert.Nil(t, w.validateMsg(newSkewTestMessage(receivedAt, unixString(ahead), unixString(ahead))))
+
+		behind := receivedAt.Add(-5 * time.Hour)
+		assert.Nil(t, w.validateMsg(newSkewTestMessage(receivedAt, unixString(behind), unixString(behind))))
+
+		// and the other way round
+		msg := newSkewTestMessage(receivedAt, unixString(receivedAt.Add(-time.Hour)), unixString(receivedAt))
+		assert.EqualError(t, w.validateMsg(msg), "No valid metrics found")
+	})
+
+	t.Run("invalid messages are not observed", func(t *testing.T) {
+		w := newTestValidator(t)
+
+		msg := newSkewTestMessage(receivedAt, unixString(receivedAt), unixString(receivedAt))
+		msg.Header["platform"] = []byte("windows")
+		assert.ErrorContains(t, w.validateMsg(msg), "does not match regexp")
+
+		msg = newSkewTestMessage(receivedAt, unixString(receivedAt), unixString(receivedAt.Add(-5*time.Hour)))
+		assert.EqualError(t, w.validateMsg(msg), "No valid metrics found")
+
+		assert.ErrorContains(t, w.validateMsg(newSkewTestMessage(receivedAt, "now", unixString(receivedAt))), ClientTimeHeader)
+		assert.Empty(t, w.stats.Reset())
+	})
+}
+
+func TestObserveClockSkew(t *testing.T) {
+	stats := NewMonitoringStats()
+
+	stats.ObserveClockSkew("p", 3*time.Second)
+	stats.ObserveClockSkew("p", -5*time.Second)
+	stats.ObserveClockSkew("p", 0)
+
+	assert.Equal(t, map[string]int{
+		"clock_skew_p_messages":    3,
+		"clock_skew_p_behind":      1,
+		"clock_skew_p_ahead":       1,
+		"clock_skew_p_seconds_sum": 8,
+		"clock_skew_p_seconds_max": 5,
+	}, stats.Reset())
+}
+
+func TestValidatorListenerProjects(t *testing.T) {
+	w := newTestValidator(t)
+	listener := &UdpListenerConfig{Address: "10.0.0.1:33334", ProjectSet: map[string]bool{"other": true}}
+	ts := unixString(time.Now())
+
+	msg := &Message{
+		Header:  map[string][]byte{"project": []byte("p")},
+		Metrics: []*Metric{{Name: []byte("fps"), Value: []byte("30"), Timestamp: []byte(ts)}},
+		Packet:  &Packet{Listener: listener},
+	}
+
+	err := w.validateMsg(msg)
+	assert.ErrorIs(t, err, errProjectNotAllowed)
+	assert.ErrorContains(t, err, `"p" on 10.0.0.1:33334`)
+
+	// the drop is counted by its reason
+	chIn := make(chan *Message, 1)
+	w.chIn = chIn
+	chIn <- msg
+	close(chIn)
+	w.Run(1)
+
+	counters := w.stats.Reset()
+	assert.Equal(t, 1, counters["messages_dropped"])
+	assert.Equal(t, 1, counters["messages_dropped_project_not_allowed"])
+
+	// listeners without projects accept all of them
+	listener.ProjectSet = nil
+	msg.Metrics = []*Metric{{Name: []byte("fps"), Value: []byte("30"), Timestamp: []byte(ts)}}
+	assert.Nil(t, w.validateMsg(msg))
+}
+
+func TestValidateValue(t *testing.T) {
+	cfg, err := loadTestConfig(t, `
+projects:
+  p:
+    graphite_path: p.{metric.name}
+    metrics:
+      fps: {min: 0, max: 100}
+      nan: {min: 0, max: 100, allow_nan: true}
+      inf: {min: 0, max: 100, allow_inf: true}
+`)
+	assert.Nil(t, err)
+	metrics := cfg.Projects["p"].Metrics
+
+	tests := []struct {
+		metric string
+		value  string
+		err    string
+	}{
+		{"fps", "50", ""},
+		{"fps", "-1", "value lower than configured minimum"},
+		{"fps", "1e3", "value higher than configured maximum"},
+		{"fps", "NaN", "value is NaN"},
+		{"fps", "+NaN", "value is NaN"},
+		{"fps", "-nan", "value is NaN"},
+		{"fps", "Inf", "value is infinite"},
+		{"fps", "-Inf", "value is infinite"},
+		{"fps", "+Infinity", "value is infinite"},
+		{"nan", "NaN", ""},
+		{"nan", "+NaN", ""},
+		{"nan", "-NaN", ""},
+		{"nan", "Inf", "value is infinite"},
+		{"nan", "101", "value higher than configured maximum"},
+		{"inf", "Inf", ""},
+		{"inf", "-Inf", ""},
+		{"inf", "+inf", ""},
+		{"inf", "-Infinity", ""},
+		{"inf", "NaN", "value is NaN"},
+		{"inf", "-1", "value lower than configured minimum"},
+		{"fps", "abc", "value must be float64-compatible"},
+		{"fps", "+-NaN", "value must be float64-compatible"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.metric+" "+test.value, func(t *testing.T) {
+			err := validateValue(metrics[test.metric], &Metric{Name: []byte(test.metric), Value: []byte(test.value)})
+
+			if test.err == "" {
+				assert.Nil(t, err)
+			} else {
+				assert.EqualError(t, err, test.err)
+			}
+		})
+	}
+
+	t.Run("signed NaN is written without sign", func(t *testing.T) {
+		metric := &Metric{Name: []byte("nan"), Value: []byte("-NaN")}
+
+		assert.Nil(t, validateValue(metrics["nan"], metric))
+		assert.Equal(t, "NaN", string(metric.Value))
+	})
+}
+
+func TestValidatorMissingTimestamps(t *testing.T) {
+	receivedAt := time.Now().Truncate(time.Second).Add(250*time.Millisecond + 300*time.Microsecond)
+	newMessage := func() *Message {
+		return &Message{
+			Header: map[string][]byte{"project": []byte("p")},
+			Metrics: []*Metric{
+				{Name: []byte("fps"), Value: []byte("30")},
+				{Name: []byte("fps"), Value: []byte("40"), Timestamp: []byte(unixString(receivedAt))},
+			},
+			Packet: &Packet{ReceivedAt: receivedAt},
+		}
+	}
+
+	t.Run("filled in with the receive time", func(t *testing.T) {
+		cfg, err := loadTestConfig(t, `
+projects:
+  p:
+    graphite_path: p.{metric.name}
+    allow_missing_timestamps: true
+    metrics:
+      fps: {min: 0, max: 100}
+`)
+		assert.Nil(t, err)
+		w := NewValidatorWorker(NewSharedConfig(cfg), newTestLogger(), NewMonitoringStats(), nil, nil)
+
+		msg := newMessage()
+		assert.Nil(t, w.validateMsg(msg))
+		assert.Len(t, msg.Metrics, 2)
+
+		// the receive time is kept in milliseconds
+		assert.Equal(t, unixString(receivedAt), string(msg.Metrics[0].Timestamp))
+		assert.Equal(t, 250000000, msg.Metrics[0].Nanos)
+		assert.Equal(t, time.Millisecond, msg.Metrics[0].Precision)
+		assert.Equal(t, unixString(receivedAt), string(msg.Metrics[1].Timestamp))
+	})
+
+	t.Run("rejected", func(t *testing.T) {
+		w := newTestValidator(t)
+
+		msg := newMessage()
+		assert.Nil(t, w.validateMsg(msg))
+		assert.Len(t, msg.Metrics, 1)
+		assert.Equal(t, "40", string(msg.Metrics[0].Value))
+
+		msg = newMessage()
+		msg.Metrics = msg.Metrics[:1]
+		assert.EqualError(t, w.validateMsg(msg), "No valid metrics found")
+	})
+}
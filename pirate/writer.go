@@ -9,34 +9,73 @@ import (
 	"net/url"
 	"os"
 	"os/signal"
+	"strconv"
 	"sync"
 	"syscall"
 	"time"
 )
 
+const (
+	TcpDialTimeout = 5 * time.Second
+)
+
 func NewWriter(target string, logger *logging.Logger, stats *MonitoringStats) (MetricWriter, error) {
 	parsed, err := url.Parse(target)
 	if err != nil {
 		return nil, fmt.Errorf("Failed to create Graphite writer: %s", err)
 	}
 
+	rounding := TimestampFloor
+	if value := parsed.Query().Get("timestamp_rounding"); value != "" {
+		if err := validateRounding(value); err != nil {
+			return nil, err
+		}
+		rounding = value
+	}
+
+	format := NewGraphiteFormat(rounding)
+	if parsed.Scheme == "influx" || parsed.Query().Get("format") == "influx" {
+		if format, err = NewInfluxFormat(parsed.Query().Get("measurement")); err != nil {
+			return nil, err
+		}
+	} else if value := parsed.Query().Get("format"); value != "" && value != "graphite" {
+		return nil, fmt.Errorf(`Unsupported format (must be "graphite" or "influx"): %s`, value)
+	}
+
 	switch parsed.Scheme {
 	case "file":
-		return NewFileWriter(parsed.Path, logger, stats)
-	case "tcp":
-		return NewTcpWriter(parsed.Host, logger, stats)
+		return NewFileWriter(parsed.Path, format, logger, stats)
+	case "tcp", "influx":
+		return NewTcpWriter(parsed.Host, format, logger, stats)
+	case "pickle":
+		batchSize := PickleDefaultBatchSize
+		if value := parsed.Query().Get("batch_size"); value != "" {
+			if batchSize, err = strconv.Atoi(value); err != nil || batchSize < 1 {
+				return nil, fmt.Errorf("Invalid batch_size for pickle target: %s", value)
+			}
+		}
+
+		flushInterval := PickleDefaultFlushInterval
+		if value := parsed.Query().Get("flush_interval"); value != "" {
+			if flushInterval, err = time.ParseDuration(value); err != nil || flushInterval <= 0 {
+				return nil, fmt.Errorf("Invalid flush_interval for pickle target: %s", value)
+			}
+		}
+
+		return NewPickleWriter(parsed.Host, batchSize, flushInterval, rounding, logger, stats)
 	default:
-		return nil, fmt.Errorf(`Unsupported graphite target (scheme must be "tcp" or "file"): %s`, parsed.Scheme)
+		return nil, fmt.Errorf(`Unsupported graphite target (scheme must be "tcp", "pickle", "influx" or "file"): %s`, parsed.Scheme)
 	}
 }
 
-func NewFileWriter(filename string, logger *logging.Logger, stats *MonitoringStats) (*wrappedWriter, error) {
+func NewFileWriter(filename string, format LineFormat, logger *logging.Logger, stats *MonitoringStats) (*wrappedWriter, error) {
 	file, err := os.OpenFile(filename, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
 	if err != nil {
 		return nil, fmt.Errorf("Failed to open graphite target file %s: %s", filename, err)
 	}
 
-	reopen := func() error {
+	writer := &wrappedWriter{writer: file, format: format, logger: logger, stats: stats}
+	writer.reopen = func() error {
 		file.Close()
 
 		if file, err = os.OpenFile(filename, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644); err != nil {
@@ -44,6 +83,9 @@ func NewFileWriter(filename string, logger *logging.Logger, stats *MonitoringSta
 			return fmt.Errorf("Failed to reopen file %s: %s\n", filename, err)
 		}
 
+		// make sure Write and Close use the new handle
+		writer.writer = file
+
 		return nil
 	}
 
@@ -55,34 +97,33 @@ func NewFileWriter(filename string, logger *logging.Logger, stats *MonitoringSta
 		for {
 			<-chSig
 			logger.Debugf("[SignalHandler] Reopening grafsy file %s", filename)
-			if err := reopen(); err != nil {
+			writer.mu.Lock()
+			err := writer.reopen()
+			writer.mu.Unlock()
+
+			if err != nil {
 				logger.Errorf("[SignalHandler] %s", err)
 			}
 		}
 	}()
 
-	return &wrappedWriter{writer: file, reopen: reopen, logger: logger, stats: stats}, nil
+	return writer, nil
 }
 
-func NewTcpWriter(addr string, logger *logging.Logger, stats *MonitoringStats) (*wrappedWriter, error) {
+func NewTcpWriter(addr string, format LineFormat, logger *logging.Logger, stats *MonitoringStats) (*wrappedWriter, error) {
 	conn, err := net.Dial("tcp", addr)
 	if err != nil {
 		return nil, fmt.Errorf("TCP error: %s", err)
 	}
 
-	writer := &wrappedWriter{writer: conn, logger: logger, stats: stats}
+	writer := &wrappedWriter{writer: conn, format: format, logger: logger, stats: stats}
 	writer.reopen = func() error {
-		conn.Close()
+		writer.writer.Close()
 
-		var newWriter net.Conn
-
-		for {
-			if newWriter, err = net.Dial("tcp", addr); err == nil {
-				break
-			}
-
-			logger.Debug("[TCP Writer] Failed to reconnect, trying again in 500 ms")
-			time.Sleep(500 * time.Millisecond)
+		// a single attempt only, retries and backoff are up to the writer worker
+		newWriter, err := net.DialTimeout("tcp", addr, TcpDialTimeout)
+		if err != nil {
+			return fmt.Errorf("[TCP Writer] Failed to reconnect: %s", err)
 		}
 
 		writer.writer = newWriter
@@ -97,10 +138,24 @@ func NewTcpWriter(addr string, logger *logging.Logger, stats *MonitoringStats) (
 type MetricWriter interface {
 	Write(m *Metric) error
 	WriteRaw(path []byte, value []byte, timestamp []byte) error
+	Close() error
+}
+
+// BatchWriter writes several metrics at once. The writer worker collects up to BatchSize metrics, but waits at most
+// FlushInterval for a batch to fill up.
+type BatchWriter interface {
+	MetricWriter
+	WriteBatch(metrics []*Metric) error
+	BatchSize() int
+	FlushInterval() time.Duration
 }
 
+// LineFormat turns a metric into a line of the target's protocol, including the line break.
+type LineFormat func(m *Metric) []byte
+
 type wrappedWriter struct {
-	writer io.Writer
+	writer io.WriteCloser
+	format LineFormat
 	reopen func() error
 	logger *logging.Logger
 	mu     sync.Mutex
@@ -108,28 +163,22 @@ type wrappedWriter struct {
 }
 
 func (w *wrappedWriter) Write(m *Metric) error {
-	return w.WriteRaw(m.Name, m.Value, m.Timestamp)
-}
-
-func (w *wrappedWriter) WriteRaw(path []byte, value []byte, timestamp []byte) error {
-	buf := bytes.Join([][]byte{path, []byte(" "), value, []byte(" "), timestamp, []byte("\n")}, []byte{})
+	buf := w.format(m)
 
 	w.logger.Debugf("[Writer] Writing: %s", buf)
 
+	// the writer is replaced by reopen, which is also called by the signal handler and by dead letter replays
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
 	if _, err := w.writer.Write(buf); err != nil {
-		w.mu.Lock()
-		defer w.mu.Unlock()
+		w.logger.Warningf("[Writer] Failed to write metric, trying to reopen")
+		if err = w.reopen(); err != nil {
+			return err
+		}
 
-		// try to write again within the lock (it might be, that another goroutine already reconnected successfully)
 		if _, err := w.writer.Write(buf); err != nil {
-			w.logger.Warningf("[Writer] Failed to write metric, trying to reopen")
-			if err = w.reopen(); err != nil {
-				return err
-			}
-
-			if _, err := w.writer.Write(buf); err != nil {
-				return fmt.Errorf("[Metric Writer] Failed to write metric: %s", err)
-			}
+			return fmt.Errorf("[Metric Writer] Failed to write metric: %s", err)
 		}
 	}
 
@@ -138,3 +187,34 @@ func (w *wrappedWriter) WriteRaw(path []byte, value []byte, timestamp []byte) er
 
 	return nil
 }
+
+func (w *wrappedWriter) WriteRaw(path []byte, value []byte, timestamp []byte) error {
+	return w.Write(&Metric{Name: path, Value: value, Timestamp: timestamp})
+}
+
+func (w *wrappedWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.writer.Close()
+}
+
+func GraphiteFormat(m *Metric) []byte {
+	return graphiteLine(m.Name, m.Value, m.Timestamp)
+}
+
+// NewGraphiteFormat creates the Graphite line format, which rounds sub-second timestamps to whole seconds by the
+// given rounding or keeps them as decimal seconds with "none"
+func NewGraphiteFormat(rounding string) LineFormat {
+	if rounding == TimestampFloor {
+		return GraphiteFormat
+	}
+
+	return func(m *Metric) []byte {
+		return graphiteLine(m.Name, m.Value, graphiteTimestamp(m, rounding))
+	}
+}
+
+func graphiteLine(path []byte, value []byte, timestamp []byte) []byte {
+	return bytes.Join([][]byte{path, []byte(" "), value, []byte(" "), timestamp, []byte("\n")}, []byte{})
+}
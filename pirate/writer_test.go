@@ -0,0 +1,36 @@
+package pirate
+
+import (
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFileWriterReopen(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "metrics.log")
+	w, err := NewFileWriter(filename, GraphiteFormat, newTestLogger(), NewMonitoringStats())
+	assert.Nil(t, err)
+	defer w.Close()
+
+	// reopen like the SIGUSR1 handler does, while metrics are written concurrently
+	chWritten := make(chan struct{})
+	go func() {
+		for i := 0; i < 100; i++ {
+			assert.Nil(t, w.Write(&Metric{Name: []byte("fps"), Value: []byte("1"), Timestamp: []byte("1234567890")}))
+		}
+		close(chWritten)
+	}()
+
+	for i := 0; i < 10; i++ {
+		w.mu.Lock()
+		assert.Nil(t, w.reopen())
+		w.mu.Unlock()
+	}
+	<-chWritten
+
+	content, err := os.ReadFile(filename)
+	assert.Nil(t, err)
+	assert.Equal(t, strings.Repeat("fps 1 1234567890\n", 100), string(content))
+}
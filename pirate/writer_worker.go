@@ -1,20 +1,93 @@
 package pirate
 
 import (
+	"errors"
+	"fmt"
 	"github.com/op/go-logging"
 	"sync"
+	"time"
 )
 
+// errTargetDown is returned instead of writing while the circuit breaker is open
+var errTargetDown = errors.New("target is down")
+
+// circuitBreaker stops the writer from paying all retries for every metric while the target is down. Once a write
+// failed after all attempts, the circuit opens and metrics fail immediately, except for a single write per cooldown,
+// which probes the target and closes the circuit again on success.
+type circuitBreaker struct {
+	cooldown time.Duration
+	open     bool
+	probeAt  time.Time
+	mu       sync.Mutex
+}
+
+// attempts returns the number of attempts of the next write, which is 0 while the circuit is open
+func (b *circuitBreaker) attempts(maxAttempts int) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if !b.open {
+		return maxAttempts
+	}
+
+	now := time.Now()
+	if now.Before(b.probeAt) {
+		return 0
+	}
+	b.probeAt = now.Add(b.cooldown)
+
+	return 1
+}
+
+// failed opens the circuit and reports whether it was closed before
+func (b *circuitBreaker) failed() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	opened := !b.open
+	b.open = true
+	b.probeAt = time.Now().Add(b.cooldown)
+
+	return opened
+}
+
+// succeeded closes the circuit and reports whether it was open before
+func (b *circuitBreaker) succeeded() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	closed := b.open
+	b.open = false
+
+	return closed
+}
+
+// ready reports whether the target is up or due to be probed
+func (b *circuitBreaker) ready() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return !b.open || !time.Now().Before(b.probeAt)
+}
+
 type writerWorker struct {
-	writer   MetricWriter
-	logger   *logging.Logger
-	chMetric chan *Metric
+	writer     MetricWriter
+	retry      *RetryConfig
+	breaker    *circuitBreaker
+	deadLetter *DeadLetterFile
+	logger     *logging.Logger
+	stats      *MonitoringStats
+	chMetric   <-chan *Metric
 }
 
-func NewWriterWorker(writer MetricWriter, logger *logging.Logger, chMetric chan *Metric) *writerWorker {
+func NewWriterWorker(writer MetricWriter, retry *RetryConfig, deadLetter *DeadLetterFile, logger *logging.Logger, stats *MonitoringStats, chMetric <-chan *Metric) *writerWorker {
 	worker := new(writerWorker)
 	worker.writer = writer
+	worker.retry = retry
+	worker.breaker = &circuitBreaker{cooldown: retry.MaxBackoff}
+	worker.deadLetter = deadLetter
 	worker.logger = logger
+	worker.stats = stats
 	worker.chMetric = chMetric
 
 	return worker
@@ -33,12 +106,118 @@ func (w *writerWorker) Run(concurrency int) {
 }
 
 func (w *writerWorker) run(wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	if writer, ok := w.writer.(BatchWriter); ok {
+		w.runBatches(writer)
+		return
+	}
+
 	for metric := range w.chMetric {
-		if err := w.writer.Write(metric); err != nil {
-			w.logger.Debugf("[Writer] Re-scheduling metric")
-			w.chMetric <- metric
+		w.write(metric)
+	}
+}
+
+// runBatches writes a batch as soon as it is full or the flush interval passed, the last batch is written when the
+// channel got closed
+func (w *writerWorker) runBatches(writer BatchWriter) {
+	ticker := time.NewTicker(writer.FlushInterval())
+	defer ticker.Stop()
+
+	batch := make([]*Metric, 0, writer.BatchSize())
+	for {
+		select {
+		case metric, ok := <-w.chMetric:
+			if !ok {
+				w.writeBatch(writer, batch)
+				return
+			}
+
+			if batch = append(batch, metric); len(batch) < writer.BatchSize() {
+				continue
+			}
+		case <-ticker.C:
 		}
+
+		w.writeBatch(writer, batch)
+		batch = batch[:0]
 	}
+}
+
+func (w *writerWorker) write(metric *Metric) {
+	if err := w.attempt(1, func() error { return w.writer.Write(metric) }); err != nil {
+		w.fail([]*Metric{metric}, err)
+	}
+}
+
+func (w *writerWorker) writeBatch(writer BatchWriter, batch []*Metric) {
+	if len(batch) == 0 {
+		return
+	}
+
+	if err := w.attempt(len(batch), func() error { return writer.WriteBatch(batch) }); err != nil {
+		w.fail(batch, err)
+	}
+}
+
+// attempt calls write for the given number of metrics up to the maximum attempts of the retry policy with exponential
+// backoff in between. While the circuit breaker is open, it fails immediately or probes the target with one attempt.
+func (w *writerWorker) attempt(metrics int, write func() error) error {
+	attempts := w.breaker.attempts(w.retry.MaxAttempts)
+	if attempts == 0 {
+		return errTargetDown
+	}
+
+	backoff := w.retry.InitialBackoff
+
+	err := write()
+	for attempt := 1; err != nil && attempt < attempts; attempt++ {
+		w.logger.Debugf("[Writer] Retrying in %s (attempt %d of %d): %s", backoff, attempt+1, attempts, err)
+		w.stats.IncMetricsRetried(metrics)
+		time.Sleep(backoff)
+
+		if backoff *= 2; backoff > w.retry.MaxBackoff {
+			backoff = w.retry.MaxBackoff
+		}
 
-	wg.Done()
+		err = write()
+	}
+
+	if err != nil && w.breaker.failed() {
+		w.logger.Warningf("[Writer] Target is down, failing metrics immediately and probing it every %s: %s", w.breaker.cooldown, err)
+	} else if err == nil && w.breaker.succeeded() {
+		w.logger.Infof("[Writer] Target is up again")
+	}
+
+	return err
+}
+
+// fail moves metrics, which could not be written, to the dead letter file or drops them. Failures while the target
+// is known to be down are only logged at debug level, as the circuit breaker already logged the outage.
+func (w *writerWorker) fail(metrics []*Metric, err error) {
+	what := fmt.Sprintf("metric %s", metrics[0].Name)
+	if len(metrics) > 1 {
+		what = fmt.Sprintf("batch of %d metrics", len(metrics))
+	}
+
+	switch {
+	case errors.Is(err, errTargetDown):
+		w.logger.Debugf("[Writer] Failing %s: %s", what, err)
+	case w.deadLetter == nil:
+		w.logger.Errorf("[Writer] Dropping %s: %s", what, err)
+	default:
+		w.logger.Warningf("[Writer] Moving %s to dead letter file: %s", what, err)
+	}
+
+	if w.deadLetter == nil {
+		w.stats.IncMetricsWriteDropped(len(metrics))
+		return
+	}
+
+	for _, metric := range metrics {
+		if err := w.deadLetter.Write(metric); err != nil {
+			w.logger.Errorf("[Writer] %s", err)
+			w.stats.IncMetricsWriteDropped(1)
+		}
+	}
 }
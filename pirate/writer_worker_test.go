@@ -0,0 +1,189 @@
+package pirate
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+	"time"
+)
+
+var testRetryConfig = &RetryConfig{
+	MaxAttempts:    2,
+	InitialBackoff: time.Millisecond,
+	MaxBackoff:     time.Millisecond,
+}
+
+// recordingBatchWriter keeps all written batches and fails while failing is set
+type recordingBatchWriter struct {
+	recordingWriter
+	batches [][]*Metric
+	calls   int
+	failing bool
+}
+
+func (w *recordingBatchWriter) WriteBatch(metrics []*Metric) error {
+	w.calls++
+	if w.failing {
+		return errors.New("write failed")
+	}
+
+	w.batches = append(w.batches, append([]*Metric(nil), metrics...))
+
+	return nil
+}
+
+func (w *recordingBatchWriter) BatchSize() int {
+	return 2
+}
+
+func (w *recordingBatchWriter) FlushInterval() time.Duration {
+	return time.Hour
+}
+
+func runTestWriterWorker(writer MetricWriter, deadLetter *DeadLetterFile, stats *MonitoringStats, metrics ...*Metric) {
+	chMetric := make(chan *Metric, len(metrics))
+	for _, metric := range metrics {
+		chMetric <- metric
+	}
+	close(chMetric)
+
+	NewWriterWorker(writer, testRetryConfig, deadLetter, newTestLogger(), stats, chMetric).Run(1)
+}
+
+func TestWriterWorkerBatches(t *testing.T) {
+	writer := &recordingBatchWriter{}
+
+	runTestWriterWorker(writer, nil, NewMonitoringStats(),
+		NewMetric("a", 1, time.Unix(1234567890, 0)),
+		NewMetric("b", 2, time.Unix(1234567890, 0)),
+		NewMetric("c", 3, time.Unix(1234567890, 0)),
+	)
+
+	// the last incomplete batch is written when the channel got closed
+	assert.Len(t, writer.batches, 2)
+	assert.Len(t, writer.batches[0], 2)
+	assert.Equal(t, []byte("c"), writer.batches[1][0].Name)
+	assert.Empty(t, writer.metrics, "Metrics must not be written one by one")
+}
+
+func TestWriterWorkerFailedBatch(t *testing.T) {
+	metrics := []*Metric{
+		NewMetric("a", 1, time.Unix(1234567890, 0)),
+		NewMetric("b", 2, time.Unix(1234567890, 0)),
+	}
+
+	t.Run("dropped", func(t *testing.T) {
+		writer := &recordingBatchWriter{failing: true}
+		stats := NewMonitoringStats()
+
+		runTestWriterWorker(writer, nil, stats, metrics...)
+
+		counters := stats.Reset()
+		assert.Equal(t, 2, writer.calls)
+		assert.Equal(t, 2, counters["metrics_retried"])
+		assert.Equal(t, 2, counters["metrics_write_dropped"])
+	})
+
+	t.Run("dead lettered", func(t *testing.T) {
+		writer := &recordingBatchWriter{failing: true}
+		d := newTestDeadLetterFile(t)
+
+		runTestWriterWorker(writer, d, d.stats, metrics...)
+
+		content, err := os.ReadFile(d.filename)
+		assert.Nil(t, err)
+		assert.Equal(t, string(spillLine(metrics[0]))+string(spillLine(metrics[1])), string(content))
+		assert.Equal(t, 2, d.stats.Reset()["metrics_dead_lettered"])
+	})
+}
+
+// flakyWriter fails the given number of writes before it succeeds
+type flakyWriter struct {
+	recordingWriter
+	failures int
+	calls    int
+}
+
+func (w *flakyWriter) Write(m *Metric) error {
+	w.calls++
+	if w.calls <= w.failures {
+		return errors.New("write failed")
+	}
+
+	return w.recordingWriter.Write(m)
+}
+
+func TestWriterWorkerRetry(t *testing.T) {
+	writer := &flakyWriter{failures: 1}
+	stats := NewMonitoringStats()
+
+	runTestWriterWorker(writer, nil, stats, NewMetric("a", 1, time.Unix(1234567890, 0)))
+
+	assert.Equal(t, 2, writer.calls)
+	assert.Len(t, writer.metrics, 1)
+
+	counters := stats.Reset()
+	assert.Equal(t, 1, counters["metrics_retried"])
+	assert.Equal(t, 0, counters["metrics_write_dropped"])
+}
+
+func TestWriterWorkerDeadLetter(t *testing.T) {
+	writer := &flakyWriter{failures: 2}
+	d := newTestDeadLetterFile(t)
+	metric := newInfluxTestMetric()
+
+	runTestWriterWorker(writer, d, d.stats, metric)
+
+	content, err := os.ReadFile(d.filename)
+	assert.Nil(t, err)
+
+	deadLettered, ok := parseSpillLine(content)
+	assert.True(t, ok)
+	assert.Equal(t, metric.Name, deadLettered.Name)
+	assert.Equal(t, metric.Attributes, deadLettered.Attributes)
+
+	counters := d.stats.Reset()
+	assert.Equal(t, 1, counters["metrics_retried"])
+	assert.Equal(t, 1, counters["metrics_dead_lettered"])
+}
+
+func TestWriterWorkerCircuitBreaker(t *testing.T) {
+	writer := &flakyWriter{failures: 2}
+	stats := NewMonitoringStats()
+	retry := &RetryConfig{MaxAttempts: 2, InitialBackoff: time.Millisecond, MaxBackoff: time.Hour}
+	w := NewWriterWorker(writer, retry, nil, newTestLogger(), stats, nil)
+
+	// the target is down after the first metric failed all attempts
+	w.write(NewMetric("a", 1, time.Unix(1234567890, 0)))
+	w.write(NewMetric("b", 2, time.Unix(1234567890, 0)))
+
+	assert.Equal(t, 2, writer.calls, "Metrics must fail without writing while the target is down")
+	assert.Equal(t, 2, stats.Reset()["metrics_write_dropped"])
+
+	// the target is probed with a single attempt after the cooldown and closes the circuit on success
+	w.breaker.probeAt = time.Now()
+	w.write(NewMetric("c", 3, time.Unix(1234567890, 0)))
+	w.write(NewMetric("d", 4, time.Unix(1234567890, 0)))
+
+	assert.Equal(t, 4, writer.calls)
+	assert.Len(t, writer.metrics, 2)
+	assert.False(t, w.breaker.open)
+}
+
+func TestCircuitBreakerProbe(t *testing.T) {
+	b := &circuitBreaker{cooldown: time.Hour}
+	assert.Equal(t, 5, b.attempts(5))
+
+	assert.True(t, b.failed())
+	assert.False(t, b.failed(), "The circuit must only open once")
+	assert.Equal(t, 0, b.attempts(5))
+
+	b.probeAt = time.Now()
+	assert.Equal(t, 1, b.attempts(5))
+	assert.Equal(t, 0, b.attempts(5), "Only a single probe is allowed per cooldown")
+
+	assert.True(t, b.succeeded())
+	assert.False(t, b.succeeded())
+	assert.Equal(t, 5, b.attempts(5))
+}